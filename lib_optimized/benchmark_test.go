@@ -1,6 +1,7 @@
 package lib
 
 import (
+	"context"
 	"fmt"
 	"testing"
 	"time"
@@ -77,7 +78,7 @@ func BenchmarkEnqueue(b *testing.B) {
 	b.ReportAllocs()
 	b.ResetTimer()
 	for i := 0; i < b.N; i++ {
-		_ = g.Enqueue(jobData)
+		_, _ = g.Enqueue(jobData)
 	}
 }
 
@@ -195,7 +196,7 @@ func BenchmarkProcessJob(b *testing.B) {
 	b.ReportAllocs()
 	b.ResetTimer()
 	for i := 0; i < b.N; i++ {
-		_ = g.processJob(data, tasks)
+		_ = processData(g, context.Background(), WorkerConfig{}, data, muxFromTasks(tasks))
 	}
 }
 
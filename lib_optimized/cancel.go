@@ -0,0 +1,184 @@
+package lib
+
+import (
+	"context"
+	"errors"
+	"log"
+	"time"
+
+	"github.com/garyburd/redigo/redis"
+)
+
+const (
+	CANCEL           = "cancel"
+	CANCEL_REQUESTED = "cancel_requested"
+)
+
+// ErrJobCancelled is the cause of a running job's context cancellation
+// when the job is cancelled through CancelJob.
+var ErrJobCancelled = errors.New("job cancelled")
+
+// ErrJobFinished is returned when cancelling a job that has already
+// succeeded, failed or been cancelled.
+var ErrJobFinished = errors.New("job already finished")
+
+// luaCancel cancels the job with status record KEYS[1] and payload ARGV[1].
+// A job still on the pending list KEYS[2], delayed set KEYS[3] or retry set
+// KEYS[4] of its priority is removed, marked cancelled at ARGV[3] and its uniqueness lock
+// KEYS[5] released if held by job ID ARGV[2]; 1 is returned and the record
+// expires ARGV[4] seconds later. Otherwise the
+// job is presumed fetched: its status record is flagged with
+// CANCEL_REQUESTED and its ID published on KEYS[6] for workers, returning 2.
+// Unknown and finished jobs return -1 and 0.
+const luaCancel = `
+	local state = redis.call('HGET', KEYS[1], 'state')
+	if not state then
+		return -1
+	end
+	if state == 'succeeded' or state == 'failed' or state == 'dead' or state == 'cancelled' then
+		return 0
+	end
+	local removed = redis.call('LREM', KEYS[2], 0, ARGV[1])
+		+ redis.call('ZREM', KEYS[3], ARGV[1])
+		+ redis.call('ZREM', KEYS[4], ARGV[1])
+	if removed == 0 then
+		redis.call('HSET', KEYS[1], 'cancel_requested', 1)
+		redis.call('PUBLISH', KEYS[6], ARGV[2])
+		return 2
+	end
+	redis.call('HSET', KEYS[1], 'state', 'cancelled', 'finished_at', ARGV[3])
+	redis.call('HDEL', KEYS[1], 'payload')
+	redis.call('EXPIRE', KEYS[1], ARGV[4])
+	if KEYS[5] ~= '' and redis.call('GET', KEYS[5]) == ARGV[2] then
+		redis.call('DEL', KEYS[5])
+	end
+	return 1
+`
+
+var cancelScript = redis.NewScript(6, luaCancel)
+
+// CancelJob stops job id and reports whether it is cancelled already. A job
+// still waiting in its queue is removed and its status becomes
+// STATUS_CANCELLED at once. For a job a worker has fetched, cancellation is
+// only requested and CancelJob returns false: the worker running it cancels
+// its context with cause ErrJobCancelled, a worker about to start it skips
+// it, and a reaper recovering it from a dead worker drops it, each marking
+// it STATUS_CANCELLED. A job that finishes first keeps its final state.
+// CancelJob finds the job through its status record, which does not expire
+// before the job finishes, so a job can be cancelled however long it waits.
+func (g *Gores) CancelJob(id string) (bool, error) {
+	conn := g.pool.Get()
+	defer conn.Close()
+
+	fields, err := redis.Values(conn.Do("HMGET", g.statusKey(id), "queue", "payload"))
+	if err != nil {
+		return false, err
+	}
+	queue, _ := redis.String(fields[0], nil)
+	payload, _ := redis.Bytes(fields[1], nil)
+	if queue == "" {
+		return false, ErrJobNotFound
+	}
+	lock, priority := "", 0
+	if job, err := FromBytes(payload); err == nil {
+		lock, priority = g.uniqueLockKey(job), job.priority()
+		PutJob(job)
+	}
+
+	n, err := redis.Int(cancelScript.Do(conn,
+		g.statusKey(id),
+		g.priorityKey(queue, QUEUE_PENDING, priority),
+		g.priorityKey(queue, QUEUE_DELAYED, priority),
+		g.priorityKey(queue, QUEUE_RETRY, priority),
+		lock,
+		g.prefix+CANCEL,
+		payload, id, time.Now().Unix(), g.resultRetention()))
+	if err != nil {
+		return false, err
+	}
+	switch n {
+	case -1:
+		return false, ErrJobNotFound
+	case 0:
+		return false, ErrJobFinished
+	}
+	return n == 1, nil
+}
+
+// cancelRequested reports whether CancelJob has flagged job id.
+func (g *Gores) cancelRequested(conn redis.Conn, id string) (bool, error) {
+	return redis.Bool(conn.Do("HEXISTS", g.statusKey(id), CANCEL_REQUESTED))
+}
+
+// cancelRunning cancels job id if it is running in this process.
+func (g *Gores) cancelRunning(id string) bool {
+	cancel, ok := g.running.Load(id)
+	if ok {
+		cancel.(context.CancelCauseFunc)(ErrJobCancelled)
+	}
+	return ok
+}
+
+// cancelFlagged cancels the jobs running in this process that CancelJob has
+// flagged, catching requests published while no canceller was subscribed.
+func (g *Gores) cancelFlagged() {
+	conn := g.pool.Get()
+	defer conn.Close()
+	g.running.Range(func(id, _ any) bool {
+		requested, err := g.cancelRequested(conn, id.(string))
+		if err != nil {
+			log.Printf("Canceller could not check job %s: %v", id, err)
+			return false
+		}
+		if requested && g.cancelRunning(id.(string)) {
+			log.Printf("Cancelling job %s", id)
+		}
+		return true
+	})
+}
+
+// runCanceller listens for cancellation requests published by CancelJob
+// until ctx is done, resubscribing after connection errors. Requests made
+// while it was not subscribed are picked up on each subscription.
+func (g *Gores) runCanceller(ctx context.Context) {
+	for ctx.Err() == nil {
+		psc := redis.PubSubConn{Conn: g.pool.Get()}
+		if err := psc.Subscribe(g.prefix + CANCEL); err != nil {
+			log.Printf("Canceller could not subscribe: %v", err)
+		} else {
+			unsubscribed := make(chan struct{})
+			stop := context.AfterFunc(ctx, func() {
+				psc.Unsubscribe()
+				close(unsubscribed)
+			})
+		receive:
+			for {
+				switch v := psc.Receive().(type) {
+				case redis.Message:
+					if g.cancelRunning(string(v.Data)) {
+						log.Printf("Cancelling job %s", v.Data)
+					}
+				case redis.Subscription:
+					if v.Count == 0 {
+						break receive
+					}
+					g.cancelFlagged()
+				case error:
+					if ctx.Err() == nil {
+						log.Printf("Canceller failed: %v", v)
+					}
+					break receive
+				}
+			}
+			if !stop() {
+				<-unsubscribed
+			}
+		}
+		psc.Close()
+
+		select {
+		case <-ctx.Done():
+		case <-time.After(time.Second):
+		}
+	}
+}
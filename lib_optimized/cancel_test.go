@@ -0,0 +1,190 @@
+package lib
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/garyburd/redigo/redis"
+)
+
+func TestCancelQueuedJob(t *testing.T) {
+	cfg := newTestConfig()
+	cfg.ResultRetention = 60
+	g := NewGores(cfg)
+	defer g.Close()
+
+	const queue = "cancel_queue"
+	conn := g.pool.Get()
+	defer conn.Close()
+	_, _ = conn.Do("DEL", g.queueKey(queue, QUEUE_PENDING), g.queueKey(queue, QUEUE_DELAYED), g.prefix+UNIQUE+"nightly")
+
+	ctx := context.Background()
+	pending, err := g.EnqueueJob(ctx, NewTask("Report", nil), WithQueue(queue), WithUniqueKey("nightly"))
+	if err != nil {
+		t.Fatalf("enqueue: %v", err)
+	}
+	delayed, err := g.EnqueueJob(ctx, NewTask("Report", nil), WithQueue(queue), WithDelay(time.Hour))
+	if err != nil {
+		t.Fatalf("enqueue delayed: %v", err)
+	}
+
+	for _, id := range []string{pending, delayed} {
+		// However long the job waits, its record outlives the result retention.
+		if ttl, _ := redis.Int(conn.Do("TTL", g.statusKey(id))); ttl != -1 {
+			t.Fatalf("expected the status of waiting job %s not to expire, got TTL %d", id, ttl)
+		}
+		if cancelled, err := g.CancelJob(id); err != nil || !cancelled {
+			t.Fatalf("CancelJob(%s): %v, %v", id, cancelled, err)
+		}
+		if s, _ := g.GetJobStatus(id); s == nil || s.State != STATUS_CANCELLED {
+			t.Fatalf("expected cancelled status, got %+v", s)
+		}
+		if ttl, _ := redis.Int(conn.Do("TTL", g.statusKey(id))); ttl <= 0 || ttl > cfg.ResultRetention {
+			t.Fatalf("expected the cancelled status to expire after the result retention, got TTL %d", ttl)
+		}
+	}
+	if n, _ := redis.Int(conn.Do("LLEN", g.queueKey(queue, QUEUE_PENDING))); n != 0 {
+		t.Fatalf("expected pending list to be empty, got %d", n)
+	}
+	if n, _ := redis.Int(conn.Do("ZCARD", g.queueKey(queue, QUEUE_DELAYED))); n != 0 {
+		t.Fatalf("expected delayed set to be empty, got %d", n)
+	}
+	if _, err := g.EnqueueJob(ctx, NewTask("Report", nil), WithQueue(queue), WithUniqueKey("nightly")); err != nil {
+		t.Fatalf("expected cancel to release the unique lock, got %v", err)
+	}
+
+	if _, err := g.CancelJob(pending); !errors.Is(err, ErrJobFinished) {
+		t.Fatalf("expected ErrJobFinished, got %v", err)
+	}
+	if _, err := g.CancelJob("no-such-job"); !errors.Is(err, ErrJobNotFound) {
+		t.Fatalf("expected ErrJobNotFound, got %v", err)
+	}
+}
+
+func TestCancelRunningJob(t *testing.T) {
+	cfg := newTestConfig()
+	g := NewGores(cfg)
+	defer g.Close()
+
+	const queue = "cancel_queue"
+	conn := g.pool.Get()
+	defer conn.Close()
+	_, _ = conn.Do("DEL", g.queueKey(queue, QUEUE_PENDING), g.processingKey(queue, testWorker))
+
+	ctx, stop := context.WithCancel(context.Background())
+	defer stop()
+	go g.runCanceller(ctx)
+
+	id, err := g.EnqueueJob(ctx, NewTask("Transcode", nil), WithQueue(queue))
+	if err != nil {
+		t.Fatalf("enqueue: %v", err)
+	}
+	started := make(chan struct{})
+	mux := NewServeMux()
+	mux.HandleFunc("Transcode", func(ctx context.Context, job *Job) error {
+		close(started)
+		<-ctx.Done()
+		return ctx.Err()
+	})
+	_, data, _ := g.fetch(conn, testWorker, []string{queue})
+	done := make(chan struct{})
+	go func() {
+		g.handle(context.Background(), conn, WorkerConfig{}, testWorker, queue, data, mux)
+		close(done)
+	}()
+
+	<-started
+	// The canceller may not be subscribed yet; the request must not be lost.
+	if cancelled, err := g.CancelJob(id); err != nil || cancelled {
+		t.Fatalf("expected cancellation to be requested, got %v, %v", cancelled, err)
+	}
+	select {
+	case <-done:
+	case <-time.After(2 * time.Second):
+		t.Fatal("running job was not cancelled")
+	}
+
+	if s, _ := g.GetJobStatus(id); s == nil || s.State != STATUS_CANCELLED {
+		t.Fatalf("expected cancelled status, got %+v", s)
+	}
+	if n, _ := redis.Int(conn.Do("LLEN", g.processingKey(queue, testWorker))); n != 0 {
+		t.Fatalf("expected cancelled job to leave the processing list, got %d", n)
+	}
+}
+
+func TestCancelFetchedJob(t *testing.T) {
+	cfg := newTestConfig()
+	g := NewGores(cfg)
+	defer g.Close()
+
+	const queue = "cancel_queue"
+	conn := g.pool.Get()
+	defer conn.Close()
+	_, _ = conn.Do("DEL", g.queueKey(queue, QUEUE_PENDING), g.processingKey(queue, testWorker))
+
+	ctx := context.Background()
+	id, err := g.EnqueueJob(ctx, NewTask("Transcode", nil), WithQueue(queue))
+	if err != nil {
+		t.Fatalf("enqueue: %v", err)
+	}
+	_, data, _ := g.fetch(conn, testWorker, []string{queue})
+	if cancelled, err := g.CancelJob(id); err != nil || cancelled {
+		t.Fatalf("expected cancellation to be requested, got %v, %v", cancelled, err)
+	}
+
+	ran := false
+	mux := NewServeMux()
+	mux.HandleFunc("Transcode", func(ctx context.Context, job *Job) error {
+		ran = true
+		return nil
+	})
+	g.handle(ctx, conn, WorkerConfig{}, testWorker, queue, data, mux)
+
+	if ran {
+		t.Fatal("expected the cancelled job not to run")
+	}
+	if s, _ := g.GetJobStatus(id); s == nil || s.State != STATUS_CANCELLED {
+		t.Fatalf("expected cancelled status, got %+v", s)
+	}
+	if n, _ := redis.Int(conn.Do("LLEN", g.processingKey(queue, testWorker))); n != 0 {
+		t.Fatalf("expected cancelled job to leave the processing list, got %d", n)
+	}
+}
+
+func TestCancelOrphanedJob(t *testing.T) {
+	cfg := newTestConfig()
+	g := NewGores(cfg)
+	defer g.Close()
+
+	const queue = "cancel_queue"
+	const dead = "dead-worker"
+	conn := g.pool.Get()
+	defer conn.Close()
+	_, _ = conn.Do("DEL", g.queueKey(queue, QUEUE_PENDING), g.processingKey(queue, dead))
+
+	id, err := g.EnqueueJob(context.Background(), NewTask("Transcode", nil), WithQueue(queue))
+	if err != nil {
+		t.Fatalf("enqueue: %v", err)
+	}
+	if _, data, _ := g.fetch(conn, dead, []string{queue}); data == nil {
+		t.Fatal("fetch: no job")
+	}
+	if _, err := g.CancelJob(id); err != nil {
+		t.Fatalf("CancelJob: %v", err)
+	}
+
+	if n, err := g.recoverList(conn, queue, g.processingKey(queue, dead), DEFAULT_MAX_RECOVERIES); err != nil || n != 0 {
+		t.Fatalf("expected nothing to be requeued, got %d (%v)", n, err)
+	}
+	if n, _ := redis.Int(conn.Do("LLEN", g.queueKey(queue, QUEUE_PENDING))); n != 0 {
+		t.Fatalf("expected cancelled job not to be requeued, got %d pending", n)
+	}
+	if n, _ := redis.Int(conn.Do("LLEN", g.processingKey(queue, dead))); n != 0 {
+		t.Fatalf("expected processing list to be drained, got %d", n)
+	}
+	if s, _ := g.GetJobStatus(id); s == nil || s.State != STATUS_CANCELLED {
+		t.Fatalf("expected cancelled status, got %+v", s)
+	}
+}
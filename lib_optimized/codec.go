@@ -0,0 +1,139 @@
+package lib
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"math"
+
+	"github.com/vmihailenco/msgpack/v5"
+)
+
+const (
+	CODEC_MSGPACK = "msgpack"
+	CODEC_JSON    = "json"
+)
+
+// Codec encodes and decodes typed job payloads.
+type Codec interface {
+	Marshal(v interface{}) ([]byte, error)
+	Unmarshal(data []byte, v interface{}) error
+}
+
+type MsgpackCodec struct{}
+
+func (MsgpackCodec) Marshal(v interface{}) ([]byte, error)      { return msgpack.Marshal(v) }
+func (MsgpackCodec) Unmarshal(data []byte, v interface{}) error { return msgpack.Unmarshal(data, v) }
+
+type JSONCodec struct{}
+
+func (JSONCodec) Marshal(v interface{}) ([]byte, error)      { return json.Marshal(v) }
+func (JSONCodec) Unmarshal(data []byte, v interface{}) error { return json.Unmarshal(data, v) }
+
+// codecByName maps a config codec name to its Codec; "" means msgpack.
+func codecByName(name string) (Codec, error) {
+	switch name {
+	case "", CODEC_MSGPACK:
+		return MsgpackCodec{}, nil
+	case CODEC_JSON:
+		return JSONCodec{}, nil
+	}
+	return nil, fmt.Errorf("unknown codec %q", name)
+}
+
+// codecName returns the name codecByName knows c by, or "" for other codecs.
+func codecName(c Codec) string {
+	switch c.(type) {
+	case MsgpackCodec:
+		return CODEC_MSGPACK
+	case JSONCodec:
+		return CODEC_JSON
+	}
+	return ""
+}
+
+type codecKey struct{}
+
+func withCodec(ctx context.Context, c Codec) context.Context {
+	return context.WithValue(ctx, codecKey{}, c)
+}
+
+// codecFromContext returns the codec the worker put in ctx, or msgpack.
+func codecFromContext(ctx context.Context) Codec {
+	if c, ok := ctx.Value(codecKey{}).(Codec); ok {
+		return c
+	}
+	return MsgpackCodec{}
+}
+
+// decodePayload decodes the job's typed payload into v with the codec that
+// encoded it, falling back to c for payloads that do not name one. Jobs
+// enqueued with plain Args are decoded by round-tripping the args through c.
+func decodePayload(c Codec, job *Job, v interface{}) error {
+	data := job.Payload
+	if len(data) > 0 && job.Codec != "" {
+		var err error
+		if c, err = codecByName(job.Codec); err != nil {
+			return err
+		}
+	}
+	if len(data) == 0 {
+		var err error
+		if data, err = c.Marshal(normalizeNumbers(job.Args)); err != nil {
+			return err
+		}
+	}
+	return c.Unmarshal(data, v)
+}
+
+// normalizeNumbers returns a copy of v with integral float64 values, as
+// produced for every number by JSON-style producers, converted to int64 so
+// strict codecs can still decode them into integer fields.
+func normalizeNumbers(v interface{}) interface{} {
+	switch t := v.(type) {
+	case float64:
+		if t == math.Trunc(t) && math.Abs(t) < 1<<53 {
+			return int64(t)
+		}
+	case map[string]interface{}:
+		out := make(map[string]interface{}, len(t))
+		for k, e := range t {
+			out[k] = normalizeNumbers(e)
+		}
+		return out
+	case []interface{}:
+		out := make([]interface{}, len(t))
+		for i, e := range t {
+			out[i] = normalizeNumbers(e)
+		}
+		return out
+	}
+	return v
+}
+
+// RegisterTyped registers fn for the task name on mux. Each job's payload is
+// decoded into a T with the codec it was enqueued with, whatever codec the
+// worker is configured with; decode failures are reported as non-retryable
+// errors.
+func RegisterTyped[T any](mux *ServeMux, name string, fn func(ctx context.Context, payload T) error) {
+	mux.HandleFunc(name, func(ctx context.Context, job *Job) error {
+		var payload T
+		if err := decodePayload(codecFromContext(ctx), job, &payload); err != nil {
+			return NonRetryable(fmt.Errorf("decode %s payload: %w", name, err))
+		}
+		return fn(ctx, payload)
+	})
+}
+
+// EnqueueTyped encodes payload with g's configured codec, recorded on the
+// job, and enqueues it as a retryable job for the task name, on
+// DEFAULT_QUEUE unless opts say otherwise, returning the job's ID.
+func EnqueueTyped[T any](ctx context.Context, g *Gores, name string, payload T, opts ...Option) (string, error) {
+	data, err := g.codec.Marshal(payload)
+	if err != nil {
+		return "", fmt.Errorf("%w: encode payload: %v", ErrInvalidJob, err)
+	}
+	job := NewTask(name, nil)
+	job.Payload, job.Codec = data, codecName(g.codec)
+	return g.EnqueueJob(ctx, job, opts...)
+}
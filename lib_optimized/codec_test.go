@@ -0,0 +1,142 @@
+package lib
+
+import (
+	"context"
+	"testing"
+)
+
+type printArgs struct {
+	ID    int     `msgpack:"id" json:"id"`
+	Note  string  `msgpack:"note" json:"note"`
+	Ratio float64 `msgpack:"ratio" json:"ratio"`
+}
+
+func TestRegisterTypedDecodesPayload(t *testing.T) {
+	for _, codec := range []Codec{MsgpackCodec{}, JSONCodec{}} {
+		mux := NewServeMux()
+		var got printArgs
+		RegisterTyped(mux, "PrintJob", func(ctx context.Context, p printArgs) error {
+			got = p
+			return nil
+		})
+
+		payload, err := codec.Marshal(printArgs{ID: 7, Note: "hi"})
+		if err != nil {
+			t.Fatalf("marshal: %v", err)
+		}
+		ctx := withCodec(context.Background(), codec)
+		if err := mux.ProcessJob(ctx, &Job{Name: "PrintJob", Payload: payload}); err != nil {
+			t.Fatalf("%T: ProcessJob: %v", codec, err)
+		}
+		if got.ID != 7 || got.Note != "hi" {
+			t.Fatalf("%T: decoded %+v", codec, got)
+		}
+	}
+}
+
+func TestRegisterTypedFallsBackToArgs(t *testing.T) {
+	mux := NewServeMux()
+	var got printArgs
+	RegisterTyped(mux, "PrintJob", func(ctx context.Context, p printArgs) error {
+		got = p
+		return nil
+	})
+
+	job := &Job{Name: "PrintJob", Args: map[string]interface{}{"id": float64(3), "ratio": float64(2)}}
+	if err := mux.ProcessJob(context.Background(), job); err != nil {
+		t.Fatalf("ProcessJob: %v", err)
+	}
+	if got.ID != 3 || got.Ratio != 2 {
+		t.Fatalf("decoded %+v from args", got)
+	}
+}
+
+func TestRegisterTypedDecodeErrorIsPermanent(t *testing.T) {
+	mux := NewServeMux()
+	RegisterTyped(mux, "PrintJob", func(ctx context.Context, p printArgs) error { return nil })
+
+	err := mux.ProcessJob(context.Background(), &Job{Name: "PrintJob", Payload: []byte{0xc1}})
+	if err == nil || IsRetryable(err) {
+		t.Fatalf("expected non-retryable decode error, got %v", err)
+	}
+}
+
+func TestEnqueueTypedRoundTrip(t *testing.T) {
+	cfg := newTestConfig()
+	g := NewGores(cfg)
+	defer g.Close()
+
+	conn := g.pool.Get()
+	defer conn.Close()
+	_, _ = conn.Do("DEL", g.queueKey(DEFAULT_QUEUE, QUEUE_PENDING))
+
+	if _, err := EnqueueTyped(context.Background(), g, "PrintJob", printArgs{ID: 11}); err != nil {
+		t.Fatalf("EnqueueTyped: %v", err)
+	}
+	_, data, err := g.fetch(conn, testWorker, []string{DEFAULT_QUEUE})
+	if err != nil || data == nil {
+		t.Fatalf("fetch: %v", err)
+	}
+
+	mux := NewServeMux()
+	var got printArgs
+	RegisterTyped(mux, "PrintJob", func(ctx context.Context, p printArgs) error {
+		got = p
+		return nil
+	})
+	if err := processData(g, context.Background(), WorkerConfig{}, data, mux); err != nil {
+		t.Fatalf("processJob: %v", err)
+	}
+	if got.ID != 11 {
+		t.Fatalf("decoded %+v", got)
+	}
+}
+
+func TestEnqueueTypedAcrossCodecs(t *testing.T) {
+	for _, names := range [][2]string{{CODEC_JSON, CODEC_MSGPACK}, {CODEC_MSGPACK, CODEC_JSON}} {
+		pcfg, wcfg := newTestConfig(), newTestConfig()
+		pcfg.Codec, wcfg.Codec = names[0], names[1]
+		producer, worker := NewGores(pcfg), NewGores(wcfg)
+
+		conn := worker.pool.Get()
+		_, _ = conn.Do("DEL", worker.queueKey(DEFAULT_QUEUE, QUEUE_PENDING))
+		id, err := EnqueueTyped(context.Background(), producer, "PrintJob", printArgs{ID: 5, Note: names[0]})
+		if err != nil {
+			t.Fatalf("EnqueueTyped: %v", err)
+		}
+		_, data, err := worker.fetch(conn, testWorker, []string{DEFAULT_QUEUE})
+		if err != nil || data == nil {
+			t.Fatalf("fetch: %v", err)
+		}
+
+		mux := NewServeMux()
+		var got printArgs
+		RegisterTyped(mux, "PrintJob", func(ctx context.Context, p printArgs) error {
+			got = p
+			return SetResult(ctx, printArgs{ID: p.ID + 1, Note: names[1]})
+		})
+		worker.handle(context.Background(), conn, WorkerConfig{}, testWorker, DEFAULT_QUEUE, data, mux)
+		if got.ID != 5 || got.Note != names[0] {
+			t.Fatalf("%s producer, %s worker: decoded %+v", names[0], names[1], got)
+		}
+		var result printArgs
+		if err := producer.GetJobResult(id, &result); err != nil || result.ID != 6 || result.Note != names[1] {
+			t.Fatalf("%s producer, %s worker: result %+v (%v)", names[0], names[1], result, err)
+		}
+		conn.Close()
+		producer.Close()
+		worker.Close()
+	}
+}
+
+func TestCodecByName(t *testing.T) {
+	if _, err := codecByName("yaml"); err == nil {
+		t.Fatal("expected error for unknown codec")
+	}
+	if c, _ := codecByName(""); c == nil {
+		t.Fatal("expected msgpack default")
+	}
+	if _, err := codecByName(CODEC_JSON); err != nil {
+		t.Fatal(err)
+	}
+}
@@ -2,9 +2,27 @@ package lib
 
 import (
 	"encoding/json"
+	"fmt"
 	"io/ioutil"
 )
 
+// WorkerConfig describes the queues a worker pool consumes and the order
+// it drains them in. Priority is PRIORITY_STRICT or PRIORITY_WEIGHTED
+// (the default); queues without a weight count as 1. MaxRecoveries bounds
+// how often a job orphaned by a dead worker is requeued before it is
+// dead-lettered. Timeout and TaskTimeouts (seconds, 0 for none) bound a
+// job's run time unless the job sets its own Timeout, and ShutdownTimeout
+// is how long running jobs may continue after a termination signal.
+type WorkerConfig struct {
+	Queues          []string       `json:"queues"`
+	Priority        string         `json:"priority"`
+	Weights         map[string]int `json:"weights"`
+	MaxRecoveries   int            `json:"max_recoveries"`
+	Timeout         int            `json:"timeout"`
+	TaskTimeouts    map[string]int `json:"task_timeouts"`
+	ShutdownTimeout int            `json:"shutdown_timeout"`
+}
+
 type Config struct {
 	Redis struct {
 		Host        string `json:"host"`
@@ -15,6 +33,17 @@ type Config struct {
 		MaxActive   int    `json:"max_active"`
 		IdleTimeout int    `json:"idle_timeout"`
 	} `json:"redis"`
+	Worker WorkerConfig `json:"worker"`
+	Codec  string       `json:"codec"`
+	// ResultRetention is how many seconds a job's status record is kept
+	// after the job finishes. Records of unfinished jobs do not expire.
+	ResultRetention int `json:"result_retention"`
+	// StatsRetention is how many days daily per-queue counters are kept.
+	StatsRetention int `json:"stats_retention"`
+	// MetricsAddr, if set, is where the produce and consume modes of the
+	// command serve Prometheus metrics. A producer keeps serving them after
+	// enqueueing, until it is interrupted.
+	MetricsAddr string `json:"metrics_addr"`
 }
 
 func InitConfig(path string) (*Config, error) {
@@ -35,5 +64,28 @@ func InitConfig(path string) (*Config, error) {
 	if cfg.Redis.MaxActive == 0 {
 		cfg.Redis.MaxActive = 200
 	}
+	if len(cfg.Worker.Queues) == 0 {
+		cfg.Worker.Queues = []string{DEFAULT_QUEUE}
+	}
+	if cfg.Worker.ShutdownTimeout == 0 {
+		cfg.Worker.ShutdownTimeout = DEFAULT_SHUTDOWN_TIMEOUT
+	}
+	if cfg.Worker.MaxRecoveries == 0 {
+		cfg.Worker.MaxRecoveries = DEFAULT_MAX_RECOVERIES
+	}
+	if cfg.ResultRetention == 0 {
+		cfg.ResultRetention = DEFAULT_RESULT_RETENTION
+	}
+	if cfg.StatsRetention == 0 {
+		cfg.StatsRetention = DEFAULT_STATS_RETENTION_DAYS
+	}
+	if _, err := codecByName(cfg.Codec); err != nil {
+		return nil, err
+	}
+	switch cfg.Worker.Priority {
+	case "", PRIORITY_STRICT, PRIORITY_WEIGHTED:
+	default:
+		return nil, fmt.Errorf("unknown worker priority %q", cfg.Worker.Priority)
+	}
 	return &cfg, nil
 }
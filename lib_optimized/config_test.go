@@ -121,3 +121,46 @@ func TestInitConfig_PartialDefaults(t *testing.T) {
 		t.Errorf("MaxIdle default failed: got %d", cfg.Redis.MaxIdle)
 	}
 }
+
+func TestInitConfig_WorkerQueues(t *testing.T) {
+	tmpDir := t.TempDir()
+	configPath := filepath.Join(tmpDir, "worker.json")
+	cfgJSON := `{"worker": {"queues": ["emails", "reports"]}}`
+	if err := os.WriteFile(configPath, []byte(cfgJSON), 0644); err != nil {
+		t.Fatalf("failed to write test file: %v", err)
+	}
+
+	cfg, err := InitConfig(configPath)
+	if err != nil {
+		t.Fatalf("InitConfig failed: %v", err)
+	}
+	if len(cfg.Worker.Queues) != 2 || cfg.Worker.Queues[1] != "reports" {
+		t.Errorf("unexpected queues: %v", cfg.Worker.Queues)
+	}
+
+	cfg, err = InitConfig(writeEmptyConfig(t))
+	if err != nil {
+		t.Fatalf("InitConfig failed: %v", err)
+	}
+	if len(cfg.Worker.Queues) != 1 || cfg.Worker.Queues[0] != DEFAULT_QUEUE {
+		t.Errorf("expected default queue, got %v", cfg.Worker.Queues)
+	}
+}
+
+func writeEmptyConfig(t *testing.T) string {
+	path := filepath.Join(t.TempDir(), "empty.json")
+	if err := os.WriteFile(path, []byte(`{}`), 0644); err != nil {
+		t.Fatalf("failed to write test file: %v", err)
+	}
+	return path
+}
+
+func TestInitConfig_UnknownPriority(t *testing.T) {
+	configPath := filepath.Join(t.TempDir(), "priority.json")
+	if err := os.WriteFile(configPath, []byte(`{"worker": {"priority": "fifo"}}`), 0644); err != nil {
+		t.Fatalf("failed to write test file: %v", err)
+	}
+	if _, err := InitConfig(configPath); err == nil {
+		t.Fatal("expected error for unknown priority mode")
+	}
+}
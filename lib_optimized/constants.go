@@ -1,10 +1,20 @@
 package lib
 
 const (
-	PREFIX         = "gores:"
-	QUEUE_PENDING  = ":pending"
-	QUEUE_PROCESS  = ":processing"
-	QUEUE_DELAYED  = ":delayed"
-	STAT_ENQUEUED  = "stat:enqueued"
-	STAT_PROCESSED = "stat:processed"
+	PREFIX           = "gores:"
+	DEFAULT_QUEUE    = "demo_queue"
+	QUEUE_PENDING    = ":pending"
+	QUEUE_PROCESS    = ":processing"
+	QUEUE_DELAYED    = ":delayed"
+	QUEUE_RETRY      = ":retry"
+	QUEUE_DEADLETTER = "_deadletter"
+	QUEUE_POISON     = ":poison"
+	STAT_ENQUEUED    = "stat:enqueued"
+	STAT_PROCESSED   = "stat:processed"
+	STAT_SUCCEEDED   = "stat:succeeded"
+	STAT_FAILED      = "stat:failed"
+	STAT_RETRIED     = "stat:retried"
+	STAT_DEAD        = "stat:dead"
+
+	DEFAULT_SHUTDOWN_TIMEOUT = 10
 )
@@ -0,0 +1,388 @@
+package lib
+
+import (
+	"time"
+
+	"github.com/garyburd/redigo/redis"
+)
+
+const DLQ_SCAN_BATCH = 100
+
+// DeadJobs returns up to limit jobs from queue's dead-letter list, newest
+// first, skipping the first offset. Each job carries its LastError,
+// FailedAt and Attempts; callers may return them with PutJob. Entries that
+// cannot be decoded are moved to the poison list, so pages stay full.
+func (g *Gores) DeadJobs(queue string, offset, limit int) ([]*Job, error) {
+	if limit <= 0 {
+		return nil, nil
+	}
+	conn := g.pool.Get()
+	defer conn.Close()
+
+	key := g.queueKey(queue, QUEUE_DEADLETTER)
+	jobs := make([]*Job, 0, limit)
+	for len(jobs) < limit {
+		items, err := redis.ByteSlices(conn.Do("LRANGE", key, offset+len(jobs), offset+limit-1))
+		if err != nil {
+			return nil, err
+		}
+		quarantined := false
+		for _, data := range items {
+			job, err := FromBytes(data)
+			if err != nil {
+				// The entries after it shift up; read them again.
+				if err := g.quarantine(conn, key, queue, data, err); err != nil {
+					return nil, err
+				}
+				quarantined = true
+				break
+			}
+			jobs = append(jobs, job)
+		}
+		if !quarantined {
+			break
+		}
+	}
+	return jobs, nil
+}
+
+// DeadLen returns the length of queue's dead-letter list.
+func (g *Gores) DeadLen(queue string) (int, error) {
+	conn := g.pool.Get()
+	defer conn.Close()
+	return redis.Int(conn.Do("LLEN", g.queueKey(queue, QUEUE_DEADLETTER)))
+}
+
+// DeadJob returns the dead job id from queue's dead-letter list, or
+// ErrJobNotFound.
+func (g *Gores) DeadJob(queue, id string) (*Job, error) {
+	conn := g.pool.Get()
+	defer conn.Close()
+
+	_, job, err := g.findDead(conn, queue, id)
+	return job, err
+}
+
+// RequeueDead moves dead job id back onto queue's pending list with its
+// retry count, recoveries and failure record cleared. It returns
+// ErrJobNotFound if the job leaves the dead-letter list meanwhile.
+func (g *Gores) RequeueDead(queue, id string) error {
+	conn := g.pool.Get()
+	defer conn.Close()
+
+	data, job, err := g.findDead(conn, queue, id)
+	if err != nil {
+		return err
+	}
+	defer PutJob(job)
+	n, err := g.requeueDead(conn, queue, data, job)
+	if err == nil && n == 0 {
+		err = ErrJobNotFound
+	}
+	return err
+}
+
+// luaQueueRequeued is the tail of the requeueing scripts: it marks the
+// status record status of a job requeued from the dead-letter list as
+// queued for task name on queue with payload, and stops it expiring.
+const luaQueueRequeued = `
+	redis.call('HSET', status, 'state', 'queued', 'name', name, 'queue', queue,
+		'retry_count', 0, 'error', '', 'payload', payload)
+	redis.call('PERSIST', status)
+`
+
+// luaDropDead is the tail of the scripts removing dead jobs for good: it
+// deletes the status records KEYS[first] on that still mark their job
+// dead or failed, so removed jobs are not reported as dead.
+const luaDropDead = `
+	for i = first, #KEYS do
+		local state = redis.call('HGET', KEYS[i], 'state')
+		if state == 'dead' or state == 'failed' then
+			redis.call('DEL', KEYS[i])
+		end
+	end
+`
+
+// luaRequeueDead pops entries off the tail of the dead-letter list KEYS[1]
+// while they match those the caller read: entry i, ARGV[3i-1], is replaced
+// by ARGV[3i] on the pending list KEYS[2i+1] of its priority and its status
+// record KEYS[2i+2] is queued for task ARGV[3i+1] on queue ARGV[1] and no
+// longer expires. Entries without those keys go to the poison list KEYS[2]
+// instead. It returns how many entries were popped and how many requeued.
+const luaRequeueDead = `
+	local popped, requeued = 0, 0
+	for i = 1, (#KEYS - 2) / 2 do
+		local entry = redis.call('RPOP', KEYS[1])
+		if entry ~= ARGV[3 * i - 1] then
+			if entry then
+				redis.call('RPUSH', KEYS[1], entry)
+			end
+			break
+		end
+		popped = popped + 1
+		local pending, status = KEYS[2 * i + 1], KEYS[2 * i + 2]
+		if status == '' then
+			redis.call('LPUSH', KEYS[2], ARGV[3 * i])
+		else
+			local name, queue, payload = ARGV[3 * i + 1], ARGV[1], ARGV[3 * i]
+			redis.call('LPUSH', pending, payload)
+` + luaQueueRequeued + `
+			requeued = requeued + 1
+		end
+	end
+	return {popped, requeued}
+`
+
+// luaRequeueDeadJob removes ARGV[1] from the dead-letter list KEYS[1] and,
+// if it was still there, pushes its reset copy ARGV[2] onto the pending
+// list KEYS[2] and queues its status record KEYS[3] for task ARGV[3] on
+// queue ARGV[4]. It returns 1 if the job was requeued.
+const luaRequeueDeadJob = `
+	if redis.call('LREM', KEYS[1], 1, ARGV[1]) == 0 then
+		return 0
+	end
+	local status, name, queue, payload = KEYS[3], ARGV[3], ARGV[4], ARGV[2]
+	redis.call('LPUSH', KEYS[2], payload)
+` + luaQueueRequeued + `
+	return 1
+`
+
+// luaDeleteDead removes ARGV[1] from the dead-letter list KEYS[1] and, if
+// it was still there, deletes its status record KEYS[2], returning 1.
+const luaDeleteDead = `
+	if redis.call('LREM', KEYS[1], 1, ARGV[1]) == 0 then
+		return 0
+	end
+	local first = 2
+` + luaDropDead + `
+	return 1
+`
+
+// luaTrimDead drops the last ARGV[1] entries of the dead-letter list KEYS[1]
+// if the first of them is still ARGV[2], deleting their status records
+// KEYS[2] on and returning 1, or returns 0 if it is not.
+const luaTrimDead = `
+	local n = tonumber(ARGV[1])
+	if redis.call('LINDEX', KEYS[1], -n) ~= ARGV[2] then
+		return 0
+	end
+	redis.call('LTRIM', KEYS[1], 0, -n - 1)
+	local first = 2
+` + luaDropDead + `
+	return 1
+`
+
+var (
+	requeueDeadScript    = redis.NewScript(-1, luaRequeueDead)
+	requeueDeadJobScript = redis.NewScript(3, luaRequeueDeadJob)
+	deleteDeadScript     = redis.NewScript(2, luaDeleteDead)
+	trimDeadScript       = redis.NewScript(-1, luaTrimDead)
+)
+
+// RequeueAllDead moves the jobs on queue's dead-letter list back onto the
+// pending lists of their priorities, DLQ_SCAN_BATCH at a time from the oldest, and returns how
+// many were moved. Jobs dead-lettered meanwhile are left for the next call.
+// Entries that cannot be decoded are moved to the poison list.
+func (g *Gores) RequeueAllDead(queue string) (int, error) {
+	conn := g.pool.Get()
+	defer conn.Close()
+
+	key := g.queueKey(queue, QUEUE_DEADLETTER)
+	left, err := redis.Int(conn.Do("LLEN", key))
+	if err != nil {
+		return 0, err
+	}
+	moved := 0
+	for left > 0 {
+		items, err := redis.ByteSlices(conn.Do("LRANGE", key, -min(left, DLQ_SCAN_BATCH), -1))
+		if err != nil || len(items) == 0 {
+			return moved, err
+		}
+		keys := []interface{}{key, g.queueKey(queue, QUEUE_POISON)}
+		argv := []interface{}{queue}
+		for i := len(items) - 1; i >= 0; i-- {
+			job, err := FromBytes(items[i])
+			if err != nil {
+				out, err := newPoisonMessage(queue, items[i], err).encode()
+				if err != nil {
+					return moved, err
+				}
+				keys = append(keys, "", "")
+				argv = append(argv, items[i], out, "")
+				continue
+			}
+			resetDead(job, time.Now())
+			out, err := job.ToBytes()
+			keys = append(keys, g.priorityKey(queue, QUEUE_PENDING, job.priority()), g.statusKey(job.ID))
+			argv = append(argv, items[i], out, job.Name)
+			PutJob(job)
+			if err != nil {
+				return moved, err
+			}
+		}
+		args := append(append([]interface{}{len(keys)}, keys...), argv...)
+		n, err := redis.Ints(requeueDeadScript.Do(conn, args...))
+		if err != nil {
+			return moved, err
+		}
+		// Fewer entries popped than read means the list changed under us;
+		// the next batch is read afresh.
+		left -= n[0]
+		moved += n[1]
+	}
+	return moved, nil
+}
+
+// DeleteDead removes dead job id from queue's dead-letter list, along with
+// its status record.
+func (g *Gores) DeleteDead(queue, id string) error {
+	conn := g.pool.Get()
+	defer conn.Close()
+
+	data, job, err := g.findDead(conn, queue, id)
+	if err != nil {
+		return err
+	}
+	PutJob(job)
+	_, err = deleteDeadScript.Do(conn, g.queueKey(queue, QUEUE_DEADLETTER), g.statusKey(id), data)
+	return err
+}
+
+// PurgeDead removes every job from queue's dead-letter list that failed
+// before t, along with their status records, and returns how many were
+// removed. The list is ordered by failure time, so the old jobs are
+// trimmed off its tail in one step.
+func (g *Gores) PurgeDead(queue string, t time.Time) (int, error) {
+	conn := g.pool.Get()
+	defer conn.Close()
+
+	for {
+		newest, ids, err := g.oldDead(conn, queue, float64(t.Unix()))
+		if err != nil || len(ids) == 0 {
+			return 0, err
+		}
+		keys := []interface{}{g.queueKey(queue, QUEUE_DEADLETTER)}
+		for _, id := range ids {
+			keys = append(keys, g.statusKey(id))
+		}
+		trimmed, err := redis.Bool(trimDeadScript.Do(conn, append(append([]interface{}{len(keys)}, keys...), len(ids), newest)...))
+		if err != nil || trimmed {
+			return len(ids), err
+		}
+		// The tail changed since it was read; count again.
+	}
+}
+
+// oldDead returns the IDs of the jobs at the tail of queue's dead-letter
+// list that failed before cutoff, oldest first, and the newest of their
+// entries. Entries that cannot be decoded are moved to the poison list.
+func (g *Gores) oldDead(conn redis.Conn, queue string, cutoff float64) ([]byte, []string, error) {
+	key := g.queueKey(queue, QUEUE_DEADLETTER)
+	var newest []byte
+	var ids []string
+scan:
+	for {
+		items, err := redis.ByteSlices(conn.Do("LRANGE", key, -(len(ids) + DLQ_SCAN_BATCH), -(len(ids) + 1)))
+		if err != nil {
+			return nil, nil, err
+		}
+		for i := len(items) - 1; i >= 0; i-- {
+			job, err := FromBytes(items[i])
+			if err != nil {
+				// Entries nearer the head shift into its place.
+				if err := g.quarantine(conn, key, queue, items[i], err); err != nil {
+					return nil, nil, err
+				}
+				continue scan
+			}
+			old, id := job.FailedAt < cutoff, job.ID
+			PutJob(job)
+			if !old {
+				return newest, ids, nil
+			}
+			newest, ids = items[i], append(ids, id)
+		}
+		if len(items) < DLQ_SCAN_BATCH {
+			return newest, ids, nil
+		}
+	}
+}
+
+// requeueDead moves one dead entry data, decoded as job, to pending,
+// returning 0 if it is no longer on queue's dead-letter list.
+func (g *Gores) requeueDead(conn redis.Conn, queue string, data []byte, job *Job) (int, error) {
+	resetDead(job, time.Now())
+	out, err := job.ToBytes()
+	if err != nil {
+		return 0, err
+	}
+	return redis.Int(requeueDeadJobScript.Do(conn,
+		g.queueKey(queue, QUEUE_DEADLETTER),
+		g.priorityKey(queue, QUEUE_PENDING, job.priority()),
+		g.statusKey(job.ID),
+		data, out, job.Name, queue))
+}
+
+// resetDead clears the retry count, recoveries and failure record of a
+// dead job requeued at now.
+func resetDead(job *Job, now time.Time) {
+	job.rejoin(now)
+	job.RetryCount, job.Recoveries = 0, 0
+	job.LastError, job.FailedAt, job.Attempts = "", 0, 0
+}
+
+// findDead returns the entry and decoded job for dead job id.
+func (g *Gores) findDead(conn redis.Conn, queue, id string) ([]byte, *Job, error) {
+	var found []byte
+	var foundJob *Job
+	err := g.scanDead(conn, queue, func(data []byte, job *Job) (bool, error) {
+		if job.ID != id {
+			return false, nil
+		}
+		found, foundJob = data, job
+		return true, nil
+	})
+	if err != nil {
+		return nil, nil, err
+	}
+	if foundJob == nil {
+		return nil, nil, ErrJobNotFound
+	}
+	return found, foundJob, nil
+}
+
+// scanDead calls fn for every entry of queue's dead-letter list, oldest
+// first, so entries fn removes do not shift those not yet seen. Entries
+// that cannot be decoded are moved to the poison list instead. Entries
+// dead-lettered during the scan may be missed. The job passed to fn is
+// released afterwards unless fn returns true, which also stops the scan.
+func (g *Gores) scanDead(conn redis.Conn, queue string, fn func(data []byte, job *Job) (bool, error)) error {
+	key := g.queueKey(queue, QUEUE_DEADLETTER)
+	n, err := redis.Int(conn.Do("LLEN", key))
+	if err != nil {
+		return err
+	}
+	for end := n - 1; end >= 0; end -= DLQ_SCAN_BATCH {
+		items, err := redis.ByteSlices(conn.Do("LRANGE", key, max(0, end-DLQ_SCAN_BATCH+1), end))
+		if err != nil {
+			return err
+		}
+		for i := len(items) - 1; i >= 0; i-- {
+			job, err := FromBytes(items[i])
+			if err != nil {
+				if err := g.quarantine(conn, key, queue, items[i], err); err != nil {
+					return err
+				}
+				continue
+			}
+			stop, err := fn(items[i], job)
+			if !stop {
+				PutJob(job)
+			}
+			if stop || err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
@@ -0,0 +1,193 @@
+package lib
+
+import (
+	"context"
+	"errors"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/garyburd/redigo/redis"
+)
+
+func TestDeadLetterManagement(t *testing.T) {
+	cfg := newTestConfig()
+	g := NewGores(cfg)
+	defer g.Close()
+
+	const queue = "dlq_queue"
+	conn := g.pool.Get()
+	defer conn.Close()
+	_, _ = conn.Do("DEL", g.queueKey(queue, QUEUE_PENDING), g.queueKey(queue, QUEUE_DEADLETTER), g.processingKey(queue, testWorker))
+
+	ctx := context.Background()
+	mux := NewServeMux()
+	mux.HandleFunc("Broken", func(ctx context.Context, job *Job) error { return NonRetryable(errors.New("bad input")) })
+	ids := make([]string, 3)
+	for i := range ids {
+		id, err := g.EnqueueJob(ctx, NewTask("Broken", nil), WithQueue(queue))
+		if err != nil {
+			t.Fatalf("enqueue: %v", err)
+		}
+		ids[i] = id
+		_, data, _ := g.fetch(conn, testWorker, []string{queue})
+		g.handle(ctx, conn, WorkerConfig{}, testWorker, queue, data, mux)
+	}
+
+	if n, err := g.DeadLen(queue); err != nil || n != 3 {
+		t.Fatalf("expected 3 dead jobs, got %d (%v)", n, err)
+	}
+	page, err := g.DeadJobs(queue, 1, 5)
+	if err != nil || len(page) != 2 || page[0].ID != ids[1] {
+		t.Fatalf("expected second page to start at %s, got %v (%v)", ids[1], page, err)
+	}
+	job, err := g.DeadJob(queue, ids[0])
+	if err != nil {
+		t.Fatalf("DeadJob: %v", err)
+	}
+	if job.LastError != "bad input" || job.FailedAt == 0 || job.Attempts != 1 {
+		t.Fatalf("expected failure record on dead job, got %+v", job)
+	}
+
+	if err := g.RequeueDead(queue, ids[0]); err != nil {
+		t.Fatalf("RequeueDead: %v", err)
+	}
+	_, data, _ := g.fetch(conn, testWorker, []string{queue})
+	requeued, err := FromBytes(data)
+	if err != nil || requeued.ID != ids[0] || requeued.LastError != "" {
+		t.Fatalf("expected requeued job with cleared failure, got %+v (%v)", requeued, err)
+	}
+	if s, _ := g.GetJobStatus(ids[0]); s == nil || s.State != STATUS_QUEUED || s.Queue != queue {
+		t.Fatalf("expected queued status after requeue, got %+v", s)
+	}
+
+	if err := g.DeleteDead(queue, ids[1]); err != nil {
+		t.Fatalf("DeleteDead: %v", err)
+	}
+	if _, err := g.DeadJob(queue, ids[1]); !errors.Is(err, ErrJobNotFound) {
+		t.Fatalf("expected deleted job to be gone, got %v", err)
+	}
+	if s, _ := g.GetJobStatus(ids[1]); s != nil {
+		t.Fatalf("expected deleted job's status to be gone, got %+v", s)
+	}
+
+	if n, err := g.PurgeDead(queue, time.Now().Add(-time.Hour)); err != nil || n != 0 {
+		t.Fatalf("expected nothing older than an hour, purged %d (%v)", n, err)
+	}
+	if n, err := g.PurgeDead(queue, time.Now().Add(time.Hour)); err != nil || n != 1 {
+		t.Fatalf("expected to purge 1 job, purged %d (%v)", n, err)
+	}
+	if s, _ := g.GetJobStatus(ids[2]); s != nil {
+		t.Fatalf("expected purged job's status to be gone, got %+v", s)
+	}
+}
+
+func TestRequeueVanishedDeadJob(t *testing.T) {
+	cfg := newTestConfig()
+	g := NewGores(cfg)
+	defer g.Close()
+
+	const queue = "dlq_queue"
+	conn := g.pool.Get()
+	defer conn.Close()
+	_, _ = conn.Do("DEL", g.queueKey(queue, QUEUE_PENDING), g.queueKey(queue, QUEUE_DEADLETTER), g.statusKey("vanished"))
+
+	// Found by RequeueDead, then deleted before the move.
+	job := &Job{ID: "vanished", Name: "Broken", Queue: queue}
+	data, _ := job.ToBytes()
+	conn.Do("HSET", g.statusKey(job.ID), "state", STATUS_DEAD)
+	if n, err := g.requeueDead(conn, queue, data, job); err != nil || n != 0 {
+		t.Fatalf("expected nothing to requeue, got %d (%v)", n, err)
+	}
+	if l, _ := redis.Int(conn.Do("LLEN", g.queueKey(queue, QUEUE_PENDING))); l != 0 {
+		t.Fatalf("expected nothing pending, got %d", l)
+	}
+	if s, _ := g.GetJobStatus(job.ID); s == nil || s.State != STATUS_DEAD {
+		t.Fatalf("expected the dead status to stay, got %+v", s)
+	}
+}
+
+func TestRequeueAllDead(t *testing.T) {
+	cfg := newTestConfig()
+	g := NewGores(cfg)
+	defer g.Close()
+
+	const queue = "dlq_queue"
+	conn := g.pool.Get()
+	defer conn.Close()
+	_, _ = conn.Do("DEL", g.queueKey(queue, QUEUE_PENDING), g.queueKey(queue, QUEUE_DEADLETTER), g.queueKey(queue, QUEUE_POISON))
+
+	total := DLQ_SCAN_BATCH + 20
+	for i := 0; i < total; i++ {
+		data, _ := (&Job{ID: "dead-" + strconv.Itoa(i), Name: "Broken", Queue: queue}).ToBytes()
+		conn.Do("LPUSH", g.queueKey(queue, QUEUE_DEADLETTER), data)
+	}
+	conn.Do("LPUSH", g.queueKey(queue, QUEUE_DEADLETTER), []byte{0xc1})
+
+	n, err := g.RequeueAllDead(queue)
+	if err != nil || n != total {
+		t.Fatalf("expected %d requeued, got %d (%v)", total, n, err)
+	}
+	if l, _ := redis.Int(conn.Do("LLEN", g.queueKey(queue, QUEUE_PENDING))); l != total {
+		t.Fatalf("expected %d pending, got %d", total, l)
+	}
+	if l, _ := g.DeadLen(queue); l != 0 {
+		t.Fatalf("expected the dead-letter list to be drained, got %d", l)
+	}
+	if msgs, _ := g.PoisonMessages(queue, 0, 10); len(msgs) != 1 {
+		t.Fatalf("expected the undecodable entry on the poison list, got %d", len(msgs))
+	}
+	if s, _ := g.GetJobStatus("dead-0"); s == nil || s.State != STATUS_QUEUED || s.Name != "Broken" {
+		t.Fatalf("expected queued status for requeued job, got %+v", s)
+	}
+}
+
+func TestDeadJobsAndPurgeSkipNothing(t *testing.T) {
+	cfg := newTestConfig()
+	g := NewGores(cfg)
+	defer g.Close()
+
+	const queue = "dlq_queue"
+	conn := g.pool.Get()
+	defer conn.Close()
+	key := g.queueKey(queue, QUEUE_DEADLETTER)
+	_, _ = conn.Do("DEL", key, g.queueKey(queue, QUEUE_POISON))
+
+	// Oldest first: three old failures, an undecodable entry, two recent.
+	now := time.Now()
+	for i, age := range []time.Duration{3 * time.Hour, 2 * time.Hour, time.Hour, 0, time.Minute, 0} {
+		data := []byte{0xc1}
+		if i != 3 {
+			data, _ = (&Job{ID: "dead-" + strconv.Itoa(i), Name: "Broken", Queue: queue, FailedAt: float64(now.Add(-age).Unix())}).ToBytes()
+		}
+		conn.Do("LPUSH", key, data)
+		conn.Do("HSET", g.statusKey("dead-"+strconv.Itoa(i)), "state", STATUS_DEAD)
+	}
+
+	page, err := g.DeadJobs(queue, 1, 3)
+	if err != nil || len(page) != 3 || page[0].ID != "dead-4" || page[1].ID != "dead-2" {
+		t.Fatalf("expected a full page around the undecodable entry, got %v (%v)", page, err)
+	}
+	if msgs, _ := g.PoisonMessages(queue, 0, 10); len(msgs) != 1 {
+		t.Fatalf("expected the undecodable entry on the poison list, got %d", len(msgs))
+	}
+
+	if n, err := g.PurgeDead(queue, now.Add(-90*time.Minute)); err != nil || n != 2 {
+		t.Fatalf("expected to purge 2 jobs, purged %d (%v)", n, err)
+	}
+	for i, want := range []bool{false, false, true} {
+		if s, _ := g.GetJobStatus("dead-" + strconv.Itoa(i)); (s != nil) != want {
+			t.Fatalf("expected status of dead-%d kept %v, got %+v", i, want, s)
+		}
+	}
+	left, _ := g.DeadJobs(queue, 0, 10)
+	if len(left) != 3 || left[2].ID != "dead-2" {
+		t.Fatalf("expected the three newest jobs to remain, got %v", left)
+	}
+	if n, err := g.PurgeDead(queue, now.Add(time.Hour)); err != nil || n != 3 {
+		t.Fatalf("expected to purge the rest, purged %d (%v)", n, err)
+	}
+	if l, _ := g.DeadLen(queue); l != 0 {
+		t.Fatalf("expected an empty dead-letter list, got %d", l)
+	}
+}
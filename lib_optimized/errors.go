@@ -0,0 +1,59 @@
+package lib
+
+import (
+	"errors"
+	"fmt"
+)
+
+// ErrTaskNotFound is matched (via errors.Is) by the error a ServeMux returns
+// for a job whose task name has no registered handler.
+var ErrTaskNotFound = errors.New("task not found")
+
+// ErrJobExpired is the non-retryable failure of a job whose deadline passed
+// before it could run.
+var ErrJobExpired = errors.New("job deadline exceeded")
+
+type taskNotFoundError struct {
+	name string
+}
+
+func (e *taskNotFoundError) Error() string {
+	return fmt.Sprintf("task %s not found", e.name)
+}
+
+func (e *taskNotFoundError) Is(target error) bool {
+	return target == ErrTaskNotFound
+}
+
+type nonRetryableError struct {
+	err error
+}
+
+func (e *nonRetryableError) Error() string { return e.err.Error() }
+func (e *nonRetryableError) Unwrap() error { return e.err }
+
+// NonRetryable marks err as permanent: a job failing with it is moved to the
+// dead-letter list immediately, regardless of its retry policy.
+func NonRetryable(err error) error {
+	if err == nil {
+		return nil
+	}
+	return &nonRetryableError{err: err}
+}
+
+// IsRetryable reports whether a job that failed with err may be retried.
+func IsRetryable(err error) bool {
+	var nr *nonRetryableError
+	return !errors.As(err, &nr)
+}
+
+// PanicError is the failure recorded for a job whose handler panicked.
+// It goes through the normal retry and dead-letter flow.
+type PanicError struct {
+	Value interface{}
+	Stack []byte
+}
+
+func (e *PanicError) Error() string {
+	return fmt.Sprintf("panic: %v\n%s", e.Value, e.Stack)
+}
@@ -1,7 +1,10 @@
 package lib
 
 import (
+	"context"
 	"fmt"
+	"strconv"
+	"sync"
 	"time"
 
 	"github.com/garyburd/redigo/redis"
@@ -10,17 +13,55 @@ import (
 type Gores struct {
 	pool   *redis.Pool
 	prefix string
+	config *Config
+	codec  Codec
+	ids    IDGenerator
+
+	metrics *metrics
+
+	// running maps the IDs of jobs running in this process to the
+	// context.CancelCauseFunc that cancels them.
+	running sync.Map
 }
 
+// luaEnqueue rejects job ID ARGV[4] with -1 if its status record KEYS[5]
+// already exists, which EnqueueJob reports as a DuplicateJobError. It then
+// appends job ARGV[1] to the pending list KEYS[1], or adds it to the
+// delayed set KEYS[2] if run-at score ARGV[2] is non-zero; both are those
+// of the job's priority. Unique jobs (ARGV[3] > 0) first take the lock
+// KEYS[4] for ARGV[3] seconds on behalf of job ID ARGV[4]; if it is already
+// held nothing is stored and 0 is returned. Stored jobs increment the
+// global and per-queue enqueued counters KEYS[3] and KEYS[6] and get a
+// queued status record KEYS[5] with name ARGV[5], queue ARGV[6], enqueue
+// time ARGV[7] and the payload itself, which does not expire until the job
+// finishes. The queue and task names are recorded in the sets KEYS[7] and
+// KEYS[8] for Info.
 const luaEnqueue = `
-	local queue = KEYS[1]
 	local data = ARGV[1]
-	local statKey = KEYS[2]
-	redis.call('LPUSH', queue, data)
+	local statKey = KEYS[3]
+	if redis.call('EXISTS', KEYS[5]) == 1 then
+		return -1
+	end
+	if tonumber(ARGV[3]) > 0 then
+		if not redis.call('SET', KEYS[4], ARGV[4], 'NX', 'EX', ARGV[3]) then
+			return 0
+		end
+	end
+	if tonumber(ARGV[2]) > 0 then
+		redis.call('ZADD', KEYS[2], ARGV[2], data)
+	else
+		redis.call('LPUSH', KEYS[1], data)
+	end
 	redis.call('INCR', statKey)
+	redis.call('INCR', KEYS[6])
+	redis.call('SADD', KEYS[7], ARGV[6])
+	redis.call('SADD', KEYS[8], ARGV[5])
+	redis.call('HSET', KEYS[5], 'state', 'queued', 'name', ARGV[5], 'queue', ARGV[6], 'enqueued_at', ARGV[7], 'payload', data)
 	return 1
 `
 
+var enqueueScript = redis.NewScript(8, luaEnqueue)
+
 func NewGores(config *Config) *Gores {
 	pool := &redis.Pool{
 		MaxIdle:     config.Redis.MaxIdle,
@@ -30,96 +71,237 @@ func NewGores(config *Config) *Gores {
 			return redis.Dial("tcp", fmt.Sprintf("%s:%d", config.Redis.Host, config.Redis.Port))
 		},
 	}
-	return &Gores{pool: pool, prefix: PREFIX}
+	codec, err := codecByName(config.Codec)
+	if err != nil {
+		codec = MsgpackCodec{}
+	}
+	return &Gores{pool: pool, prefix: PREFIX, config: config, codec: codec, ids: NewULIDGenerator(), metrics: newMetrics()}
+}
+
+// SetIDGenerator replaces the ULIDGenerator used for jobs enqueued without
+// an ID. It must be called before g is used.
+func (g *Gores) SetIDGenerator(ids IDGenerator) {
+	g.ids = ids
+}
+
+// queueKey returns the Redis key for one of a queue's lists, e.g. QUEUE_PENDING.
+func (g *Gores) queueKey(queue, suffix string) string {
+	return g.prefix + queue + suffix
+}
+
+// priorityKey returns the key of queue's pending list, or of its delayed or
+// retry set, holding jobs of the given priority. Each priority has its own;
+// priority 0 uses the plain queueKey.
+func (g *Gores) priorityKey(queue, suffix string, priority int) string {
+	if priority == 0 {
+		return g.queueKey(queue, suffix)
+	}
+	return g.queueKey(queue, suffix) + ":" + strconv.Itoa(priority)
+}
+
+// priorityKeys returns priorityKey for every priority, highest first.
+func (g *Gores) priorityKeys(queue, suffix string) []string {
+	keys := make([]string, 0, MAX_JOB_PRIORITY+1)
+	for p := MAX_JOB_PRIORITY; p >= 0; p-- {
+		keys = append(keys, g.priorityKey(queue, suffix, p))
+	}
+	return keys
 }
 
 func (g *Gores) Close() error {
 	return g.pool.Close()
 }
 
-func (g *Gores) Enqueue(jobData map[string]interface{}) error {
-	job := GetJob()
+// Enqueue pushes a job described by a map with the keys Name, Queue, Args
+// and Retry, plus the optional MaxRetries, Backoff, MaxBackoff and ID, and
+// returns its ID. Missing or mistyped keys are reported as errors matching
+// ErrInvalidJob.
+func (g *Gores) Enqueue(jobData map[string]interface{}) (string, error) {
+	return g.enqueue(jobData, time.Time{})
+}
+
+// EnqueueIn schedules a job to become pending once delay has elapsed.
+func (g *Gores) EnqueueIn(jobData map[string]interface{}, delay time.Duration) (string, error) {
+	return g.enqueue(jobData, time.Now().Add(delay))
+}
+
+// EnqueueAt schedules a job to become pending at runAt. Times in the past
+// enqueue the job immediately.
+func (g *Gores) EnqueueAt(jobData map[string]interface{}, runAt time.Time) (string, error) {
+	return g.enqueue(jobData, runAt)
+}
+
+func (g *Gores) enqueue(jobData map[string]interface{}, runAt time.Time) (string, error) {
+	job, err := jobFromMap(jobData)
+	if err != nil {
+		return "", err
+	}
 	defer PutJob(job)
+	if !runAt.IsZero() {
+		job.RunAt = float64(runAt.Unix())
+	}
+	if err := g.push(context.Background(), job); err != nil {
+		return "", err
+	}
+	return job.ID, nil
+}
 
-	job.ID = fmt.Sprintf("%d", time.Now().UnixNano())
-	job.Name = jobData["Name"].(string)
-	job.Queue = jobData["Queue"].(string)
-	for k, v := range jobData["Args"].(map[string]interface{}) {
-		job.Args[k] = v
+// EnqueueJob applies opts to job, validates it and pushes it onto its
+// queue, assigning an ID if it has none. It returns the job's ID, which
+// GetJobStatus accepts. See NewTask for building jobs.
+func (g *Gores) EnqueueJob(ctx context.Context, job *Job, opts ...Option) (string, error) {
+	if job == nil {
+		return "", fmt.Errorf("%w: nil job", ErrInvalidJob)
+	}
+	for _, opt := range opts {
+		opt(job)
 	}
-	job.Retry = jobData["Retry"].(bool)
-	job.EnqueueTime = float64(time.Now().Unix())
+	if err := g.push(ctx, job); err != nil {
+		return "", err
+	}
+	return job.ID, nil
+}
 
-	if err := job.Validate(); err != nil {
+// push stamps, validates and stores a single job, on the delayed set if
+// its RunAt is in the future.
+func (g *Gores) push(ctx context.Context, job *Job) error {
+	data, err := g.prepare(job)
+	if err != nil {
 		return err
 	}
-	data, err := job.ToBytes()
+
+	conn, err := g.pool.GetContext(ctx)
 	if err != nil {
 		return err
 	}
-
-	conn := g.pool.Get()
 	defer conn.Close()
 
-	queueKey := g.prefix + job.Queue + QUEUE_PENDING
-	statKey := g.prefix + STAT_ENQUEUED
-	script := redis.NewScript(2, luaEnqueue)
-	_, err = script.Do(conn, queueKey, statKey, data)
-	return err
+	stored, err := redis.Int(enqueueScript.Do(conn, g.enqueueArgs(job, data)...))
+	if err != nil {
+		return err
+	}
+	if err := duplicateError(stored, job); err != nil {
+		return err
+	}
+	g.metrics.jobEnqueued(job)
+	return nil
 }
 
-func (g *Gores) EnqueueBatch(jobs []map[string]interface{}) error {
-	if len(jobs) == 0 {
-		return nil
+// prepare stamps and validates a job and returns its encoding.
+func (g *Gores) prepare(job *Job) ([]byte, error) {
+	g.stamp(job)
+	if err := job.Validate(); err != nil {
+		return nil, err
 	}
-	conn := g.pool.Get()
-	defer conn.Close()
+	if err := job.prepareUnique(); err != nil {
+		return nil, err
+	}
+	return job.ToBytes()
+}
 
-	conn.Send("MULTI")
-	for _, jobData := range jobs {
-		job := GetJob()
-		job.ID = fmt.Sprintf("%d", time.Now().UnixNano())
-		job.Name = jobData["Name"].(string)
-		job.Queue = jobData["Queue"].(string)
-		for k, v := range jobData["Args"].(map[string]interface{}) {
-			job.Args[k] = v
-		}
-		job.Retry = jobData["Retry"].(bool)
+// enqueueArgs returns the keys and arguments of enqueueScript for job.
+func (g *Gores) enqueueArgs(job *Job, data []byte) []interface{} {
+	var runAt int64
+	if job.scheduled() {
+		runAt = int64(job.RunAt)
+	}
+	return []interface{}{
+		g.priorityKey(job.Queue, QUEUE_PENDING, job.priority()),
+		g.priorityKey(job.Queue, QUEUE_DELAYED, job.priority()),
+		g.prefix + STAT_ENQUEUED,
+		g.uniqueLockKey(job),
+		g.statusKey(job.ID),
+		g.queueStatKey(STAT_ENQUEUED, job.Queue),
+		g.prefix + QUEUES,
+		g.prefix + TASKS + job.Queue,
+		data, runAt, job.uniqueTTLSeconds(), job.ID,
+		job.Name, job.Queue, int64(job.EnqueueTime),
+	}
+}
+
+// stamp fills in the ID and enqueue time of a job that has none, records
+// when it becomes pending and marks it with the current JOB_VERSION.
+func (g *Gores) stamp(job *Job) {
+	job.Version = JOB_VERSION
+	if job.ID == "" {
+		job.ID = g.ids.NewID()
+	}
+	if job.EnqueueTime == 0 {
 		job.EnqueueTime = float64(time.Now().Unix())
+	}
+	job.PendingAt = max(job.EnqueueTime, job.RunAt)
+}
 
-		if err := job.Validate(); err != nil {
+func (g *Gores) EnqueueBatch(jobs []map[string]interface{}) error {
+	batch := make([]*Job, 0, len(jobs))
+	defer func() {
+		for _, job := range batch {
 			PutJob(job)
+		}
+	}()
+	for _, jobData := range jobs {
+		job, err := jobFromMap(jobData)
+		if err != nil {
 			return err
 		}
-		data, _ := job.ToBytes()
-		conn.Send("LPUSH", g.prefix+job.Queue+QUEUE_PENDING, data)
-		PutJob(job)
+		batch = append(batch, job)
 	}
-	_, err := conn.Do("EXEC")
-	return err
+	return g.pushBatch(context.Background(), batch)
 }
 
-func (g *Gores) Info() (map[string]interface{}, error) {
-	conn := g.pool.Get()
+// EnqueueJobs validates every job and pushes them all in one transaction.
+// Nothing is enqueued if any job is invalid. Each job's ID is set on it.
+func (g *Gores) EnqueueJobs(ctx context.Context, jobs []*Job) error {
+	for _, job := range jobs {
+		if job == nil {
+			return fmt.Errorf("%w: nil job", ErrInvalidJob)
+		}
+	}
+	return g.pushBatch(ctx, jobs)
+}
+
+// pushBatch stores every job in one transaction. Unique jobs whose lock is
+// taken are skipped and reported as a *DuplicateJobError once the rest of
+// the batch has been stored.
+func (g *Gores) pushBatch(ctx context.Context, jobs []*Job) error {
+	if len(jobs) == 0 {
+		return nil
+	}
+	payloads := make([][]byte, len(jobs))
+	for i, job := range jobs {
+		data, err := g.prepare(job)
+		if err != nil {
+			return err
+		}
+		payloads[i] = data
+	}
+
+	conn, err := g.pool.GetContext(ctx)
+	if err != nil {
+		return err
+	}
 	defer conn.Close()
 
+	if err := enqueueScript.Load(conn); err != nil {
+		return err
+	}
 	conn.Send("MULTI")
-	conn.Send("LLEN", g.prefix+"demo_queue"+QUEUE_PENDING)
-	conn.Send("GET", g.prefix+STAT_ENQUEUED)
-	conn.Send("GET", g.prefix+STAT_PROCESSED)
-	results, err := redis.Values(conn.Do("EXEC"))
+	for i, job := range jobs {
+		enqueueScript.SendHash(conn, g.enqueueArgs(job, payloads[i])...)
+	}
+	stored, err := redis.Ints(conn.Do("EXEC"))
 	if err != nil {
-		return nil, err
+		return err
 	}
-
-	pending, _ := redis.Int(results[0], nil)
-	enqueued, _ := redis.Int(results[1], nil)
-	processed, _ := redis.Int(results[2], nil)
-
-	return map[string]interface{}{
-		"pending":           pending,
-		"enqueued":          enqueued,
-		"processed":         processed,
-		"Enqueue_timestamp": float64(time.Now().Unix()),
-	}, nil
+	var dup error
+	for i, n := range stored {
+		if err := duplicateError(n, jobs[i]); err != nil {
+			if dup == nil {
+				dup = err
+			}
+			continue
+		}
+		g.metrics.jobEnqueued(jobs[i])
+	}
+	return dup
 }
@@ -1,8 +1,12 @@
 package lib
 
 import (
+	"context"
 	"encoding/json"
+	"errors"
 	"testing"
+
+	"github.com/garyburd/redigo/redis"
 )
 
 func newTestConfig() *Config {
@@ -37,7 +41,7 @@ func TestEnqueueAndInfoCounts(t *testing.T) {
 		"Args":  map[string]interface{}{"id": float64(1)},
 		"Retry": true,
 	}
-	if err := g.Enqueue(job); err != nil {
+	if _, err := g.Enqueue(job); err != nil {
 		t.Fatalf("enqueue: %v", err)
 	}
 
@@ -48,8 +52,8 @@ func TestEnqueueAndInfoCounts(t *testing.T) {
 	b, _ := json.Marshal(info)
 	_ = b // keep linter happy
 
-	if info["pending"].(int) < 1 {
-		t.Fatalf("expected pending >=1, got %#v", info["pending"])
+	if q := info.Queue("demo_queue"); q == nil || q.Pending < 1 {
+		t.Fatalf("expected pending >=1, got %#v", q)
 	}
 }
 
@@ -79,10 +83,66 @@ func TestEnqueueBatch100(t *testing.T) {
 	if err != nil {
 		t.Fatalf("info: %v", err)
 	}
-	if info["pending"].(int) < 100 {
-		t.Fatalf("expected pending >=100, got %#v", info["pending"])
+	if q := info.Queue("demo_queue"); q == nil || q.Pending < 100 {
+		t.Fatalf("expected pending >=100, got %#v", q)
+	}
+	if info.Time.IsZero() {
+		t.Fatalf("missing snapshot time")
+	}
+}
+
+func TestEnqueueMalformedMapReturnsError(t *testing.T) {
+	cfg := newTestConfig()
+	g := NewGores(cfg)
+	defer g.Close()
+
+	bad := []map[string]interface{}{
+		{"Queue": "demo_queue"},
+		{"Name": "PrintJob", "Queue": 42},
+		{"Name": "PrintJob", "Queue": "demo_queue", "Args": "id=1"},
+		{"Name": "PrintJob", "Queue": "demo_queue", "Retry": "yes"},
+	}
+	for _, jobData := range bad {
+		if _, err := g.Enqueue(jobData); !errors.Is(err, ErrInvalidJob) {
+			t.Errorf("Enqueue(%v): expected ErrInvalidJob, got %v", jobData, err)
+		}
+	}
+	if err := g.EnqueueBatch(bad); !errors.Is(err, ErrInvalidJob) {
+		t.Errorf("EnqueueBatch: expected ErrInvalidJob, got %v", err)
+	}
+}
+
+func TestEnqueueJobTyped(t *testing.T) {
+	cfg := newTestConfig()
+	g := NewGores(cfg)
+	defer g.Close()
+
+	const queue = "typed_queue"
+	conn := g.pool.Get()
+	defer conn.Close()
+	_, _ = conn.Do("DEL", g.queueKey(queue, QUEUE_PENDING))
+
+	job := NewTask("PrintJob", map[string]interface{}{"id": float64(1)})
+	job.Queue = queue
+	id, err := g.EnqueueJob(context.Background(), job)
+	if err != nil {
+		t.Fatalf("EnqueueJob: %v", err)
+	}
+	if id == "" || id != job.ID {
+		t.Fatalf("expected EnqueueJob to assign and return an ID, got %q", id)
+	}
+	if _, err := g.EnqueueJob(context.Background(), NewTask("", nil)); !errors.Is(err, ErrInvalidJob) {
+		t.Fatalf("expected ErrInvalidJob for empty name, got %v", err)
+	}
+
+	batch := []*Job{NewTask("PrintJob", nil), NewTask("CalcJob", nil)}
+	for _, j := range batch {
+		j.Queue = queue
+	}
+	if err := g.EnqueueJobs(context.Background(), batch); err != nil {
+		t.Fatalf("EnqueueJobs: %v", err)
 	}
-	if _, ok := info["Enqueue_timestamp"]; !ok {
-		t.Fatalf("missing Enqueue_timestamp")
+	if n, _ := redis.Int(conn.Do("LLEN", g.queueKey(queue, QUEUE_PENDING))); n != 3 {
+		t.Fatalf("expected 3 pending jobs, got %d", n)
 	}
 }
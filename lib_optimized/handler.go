@@ -0,0 +1,130 @@
+package lib
+
+import (
+	"context"
+	"sync"
+)
+
+// Handler processes a single attempt of a job.
+type Handler interface {
+	ProcessJob(ctx context.Context, job *Job) error
+}
+
+// HandlerFunc adapts an ordinary function to a Handler.
+type HandlerFunc func(ctx context.Context, job *Job) error
+
+func (f HandlerFunc) ProcessJob(ctx context.Context, job *Job) error {
+	return f(ctx, job)
+}
+
+// TaskFunc is a task handler that only needs the job's args. ctx carries
+// the job's deadline, if any, and is cancelled when the worker shuts down.
+type TaskFunc func(ctx context.Context, args map[string]interface{}) error
+
+func (f TaskFunc) ProcessJob(ctx context.Context, job *Job) error {
+	return f(ctx, job.Args)
+}
+
+// Middleware wraps a Handler with cross-cutting behavior such as logging,
+// metrics or tracing.
+type Middleware func(Handler) Handler
+
+// chain wraps h so that mws[0] is the outermost middleware.
+func chain(h Handler, mws []Middleware) Handler {
+	for i := len(mws) - 1; i >= 0; i-- {
+		h = mws[i](h)
+	}
+	return h
+}
+
+// ServeMux dispatches jobs to the handler registered for their task name.
+// Jobs with an unknown name fail with a non-retryable error matching
+// ErrTaskNotFound.
+type ServeMux struct {
+	mu         sync.RWMutex
+	handlers   map[string]Handler
+	middleware []Middleware
+	taskMW     map[string][]Middleware
+}
+
+func NewServeMux() *ServeMux {
+	return &ServeMux{
+		handlers: make(map[string]Handler),
+		taskMW:   make(map[string][]Middleware),
+	}
+}
+
+// Use appends middleware that wraps every job the mux dispatches, including
+// jobs for unknown tasks. Global middleware runs outside per-task middleware.
+func (mux *ServeMux) Use(mws ...Middleware) {
+	mux.mu.Lock()
+	defer mux.mu.Unlock()
+	mux.middleware = append(mux.middleware, mws...)
+}
+
+// UseFor appends middleware that only wraps jobs for the task name.
+func (mux *ServeMux) UseFor(name string, mws ...Middleware) {
+	mux.mu.Lock()
+	defer mux.mu.Unlock()
+	mux.taskMW[name] = append(mux.taskMW[name], mws...)
+}
+
+// Handle registers h for the task name. It panics if name is empty, h is
+// nil or a handler is already registered for name.
+func (mux *ServeMux) Handle(name string, h Handler) {
+	if name == "" {
+		panic("gores: empty task name")
+	}
+	if h == nil {
+		panic("gores: nil handler for task " + name)
+	}
+	mux.mu.Lock()
+	defer mux.mu.Unlock()
+	if _, exists := mux.handlers[name]; exists {
+		panic("gores: multiple registrations for task " + name)
+	}
+	mux.handlers[name] = h
+}
+
+// HandleFunc registers fn for the task name.
+func (mux *ServeMux) HandleFunc(name string, fn func(ctx context.Context, job *Job) error) {
+	mux.Handle(name, HandlerFunc(fn))
+}
+
+// Handler returns the handler registered for name, if any.
+func (mux *ServeMux) Handler(name string) (Handler, bool) {
+	mux.mu.RLock()
+	defer mux.mu.RUnlock()
+	h, ok := mux.handlers[name]
+	return h, ok
+}
+
+// ProcessJob runs the job through the mux's middleware and the handler
+// registered for its task name.
+func (mux *ServeMux) ProcessJob(ctx context.Context, job *Job) error {
+	mux.mu.RLock()
+	h, ok := mux.handlers[job.Name]
+	global, local := mux.middleware, mux.taskMW[job.Name]
+	mux.mu.RUnlock()
+
+	if !ok {
+		h = HandlerFunc(notFound)
+	}
+	return chain(chain(h, local), global).ProcessJob(ctx, job)
+}
+
+func notFound(_ context.Context, job *Job) error {
+	return NonRetryable(&taskNotFoundError{name: job.Name})
+}
+
+// muxFromTasks registers the legacy context-free task map on a new ServeMux.
+func muxFromTasks(tasks map[string]func(map[string]interface{}) error) *ServeMux {
+	mux := NewServeMux()
+	for name, fn := range tasks {
+		fn := fn
+		mux.Handle(name, TaskFunc(func(_ context.Context, args map[string]interface{}) error {
+			return fn(args)
+		}))
+	}
+	return mux
+}
@@ -0,0 +1,98 @@
+package lib
+
+import (
+	"context"
+	"errors"
+	"testing"
+)
+
+func TestServeMuxDispatch(t *testing.T) {
+	mux := NewServeMux()
+	var got string
+	mux.HandleFunc("PrintJob", func(ctx context.Context, job *Job) error {
+		got = job.ID
+		return nil
+	})
+
+	if err := mux.ProcessJob(context.Background(), &Job{ID: "7", Name: "PrintJob"}); err != nil {
+		t.Fatalf("ProcessJob: %v", err)
+	}
+	if got != "7" {
+		t.Fatalf("handler saw job %q", got)
+	}
+
+	err := mux.ProcessJob(context.Background(), &Job{Name: "Missing"})
+	if !errors.Is(err, ErrTaskNotFound) {
+		t.Fatalf("expected ErrTaskNotFound, got %v", err)
+	}
+	if IsRetryable(err) {
+		t.Fatal("unknown task should not be retryable")
+	}
+}
+
+func TestServeMuxDuplicatePanics(t *testing.T) {
+	mux := NewServeMux()
+	mux.Handle("PrintJob", TaskFunc(func(ctx context.Context, args map[string]interface{}) error { return nil }))
+	defer func() {
+		if recover() == nil {
+			t.Fatal("expected duplicate registration to panic")
+		}
+	}()
+	mux.Handle("PrintJob", TaskFunc(func(ctx context.Context, args map[string]interface{}) error { return nil }))
+}
+
+func TestNonRetryable(t *testing.T) {
+	base := errors.New("bad input")
+	err := NonRetryable(base)
+	if IsRetryable(err) || !errors.Is(err, base) || err.Error() != "bad input" {
+		t.Fatalf("unexpected wrapping: %v", err)
+	}
+	if !IsRetryable(base) {
+		t.Fatal("plain errors should be retryable")
+	}
+	if NonRetryable(nil) != nil {
+		t.Fatal("NonRetryable(nil) should be nil")
+	}
+}
+
+func TestServeMuxMiddlewareOrder(t *testing.T) {
+	var trace []string
+	record := func(tag string) Middleware {
+		return func(next Handler) Handler {
+			return HandlerFunc(func(ctx context.Context, job *Job) error {
+				trace = append(trace, tag+">")
+				err := next.ProcessJob(ctx, job)
+				trace = append(trace, "<"+tag)
+				return err
+			})
+		}
+	}
+
+	mux := NewServeMux()
+	mux.HandleFunc("Report", func(ctx context.Context, job *Job) error {
+		trace = append(trace, "handler")
+		return nil
+	})
+	mux.HandleFunc("Other", func(ctx context.Context, job *Job) error { return nil })
+	mux.Use(record("a"), record("b"))
+	mux.UseFor("Report", record("report"))
+
+	if err := mux.ProcessJob(context.Background(), &Job{Name: "Report"}); err != nil {
+		t.Fatalf("ProcessJob: %v", err)
+	}
+	want := []string{"a>", "b>", "report>", "handler", "<report", "<b", "<a"}
+	if len(trace) != len(want) {
+		t.Fatalf("trace = %v, want %v", trace, want)
+	}
+	for i := range want {
+		if trace[i] != want[i] {
+			t.Fatalf("trace = %v, want %v", trace, want)
+		}
+	}
+
+	trace = nil
+	_ = mux.ProcessJob(context.Background(), &Job{Name: "Missing"})
+	if len(trace) != 4 || trace[0] != "a>" {
+		t.Fatalf("expected global middleware around unknown task, got %v", trace)
+	}
+}
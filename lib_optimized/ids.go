@@ -0,0 +1,85 @@
+package lib
+
+import (
+	"crypto/rand"
+	"sync"
+	"time"
+)
+
+// crockford is the base32 alphabet ULIDs are written in.
+const crockford = "0123456789ABCDEFGHJKMNPQRSTVWXYZ"
+
+// IDGenerator assigns IDs to jobs enqueued without one. IDs must be unique
+// across every producer sharing a Redis instance.
+type IDGenerator interface {
+	NewID() string
+}
+
+// ULIDGenerator generates ULIDs: 26-character IDs made of a millisecond
+// timestamp and 80 random bits, so they sort by creation time. IDs created
+// in the same millisecond by one generator increment the random part and
+// stay ordered.
+type ULIDGenerator struct {
+	mu      sync.Mutex
+	lastMs  uint64
+	entropy [10]byte
+}
+
+func NewULIDGenerator() *ULIDGenerator {
+	return &ULIDGenerator{}
+}
+
+func (u *ULIDGenerator) NewID() string {
+	ms := uint64(time.Now().UnixMilli())
+
+	u.mu.Lock()
+	if ms > u.lastMs {
+		rand.Read(u.entropy[:])
+		u.lastMs = ms
+	} else if increment(u.entropy[:]) {
+		ms = u.lastMs
+	} else {
+		// The random part overflowed; move on to the next millisecond.
+		ms = u.lastMs + 1
+		rand.Read(u.entropy[:])
+		u.lastMs = ms
+	}
+	var id [16]byte
+	for i := 0; i < 6; i++ {
+		id[i] = byte(ms >> (40 - 8*i))
+	}
+	copy(id[6:], u.entropy[:])
+	u.mu.Unlock()
+
+	return encodeULID(id)
+}
+
+// increment adds one to the big-endian number b, reporting false on overflow.
+func increment(b []byte) bool {
+	for i := len(b) - 1; i >= 0; i-- {
+		b[i]++
+		if b[i] != 0 {
+			return true
+		}
+	}
+	return false
+}
+
+// encodeULID writes the 128 bits of id as 26 base32 characters, the first
+// of which carries only the top 3 bits.
+func encodeULID(id [16]byte) string {
+	var out [26]byte
+	var acc uint16
+	bits := 2 // 130 encoded bits, padded with two leading zero bits
+	pos := 0
+	for _, b := range id {
+		acc = acc<<8 | uint16(b)
+		bits += 8
+		for bits >= 5 {
+			bits -= 5
+			out[pos] = crockford[(acc>>uint(bits))&31]
+			pos++
+		}
+	}
+	return string(out[:])
+}
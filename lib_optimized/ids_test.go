@@ -0,0 +1,74 @@
+package lib
+
+import (
+	"context"
+	"errors"
+	"sort"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+func TestULIDsAreUniqueAndSorted(t *testing.T) {
+	gen := NewULIDGenerator()
+	ids := make([]string, 10000)
+	seen := make(map[string]bool, len(ids))
+	for i := range ids {
+		id := gen.NewID()
+		if len(id) != 26 || strings.Trim(id, crockford) != "" {
+			t.Fatalf("malformed ULID %q", id)
+		}
+		if seen[id] {
+			t.Fatalf("duplicate ULID %q", id)
+		}
+		seen[id] = true
+		ids[i] = id
+	}
+	if !sort.StringsAreSorted(ids) {
+		t.Fatal("expected ULIDs from one generator to be sorted")
+	}
+}
+
+func TestIncrementOverflow(t *testing.T) {
+	b := []byte{0x00, 0xff}
+	if !increment(b) || b[0] != 1 || b[1] != 0 {
+		t.Fatalf("expected carry, got %v", b)
+	}
+	b = []byte{0xff, 0xff}
+	if increment(b) {
+		t.Fatal("expected overflow")
+	}
+}
+
+type seqIDs struct{ n int }
+
+func (s *seqIDs) NewID() string {
+	s.n++
+	return "seq-" + strconv.Itoa(s.n)
+}
+
+func TestWithIDIsIdempotent(t *testing.T) {
+	cfg := newTestConfig()
+	g := NewGores(cfg)
+	defer g.Close()
+
+	const queue = "ids_queue"
+	conn := g.pool.Get()
+	defer conn.Close()
+	_, _ = conn.Do("DEL", g.queueKey(queue, QUEUE_PENDING), g.statusKey("order-7"), g.statusKey("seq-1"))
+
+	ctx := context.Background()
+	if id, err := g.EnqueueJob(ctx, NewTask("Ship", nil), WithQueue(queue), WithID("order-7")); err != nil || id != "order-7" {
+		t.Fatalf("enqueue: %q, %v", id, err)
+	}
+	_, err := g.EnqueueJob(ctx, NewTask("Ship", nil), WithQueue(queue), WithID("order-7"))
+	var dup *DuplicateJobError
+	if !errors.As(err, &dup) || dup.ID != "order-7" {
+		t.Fatalf("expected DuplicateJobError for reused ID, got %v", err)
+	}
+
+	g.SetIDGenerator(&seqIDs{})
+	if id, err := g.EnqueueJob(ctx, NewTask("Ship", nil), WithQueue(queue)); err != nil || id != "seq-1" {
+		t.Fatalf("expected custom generator ID, got %q, %v", id, err)
+	}
+}
@@ -0,0 +1,179 @@
+package lib
+
+import (
+	"sort"
+	"time"
+
+	"github.com/garyburd/redigo/redis"
+)
+
+const (
+	QUEUES = "queues"
+	TASKS  = "tasks:"
+)
+
+// Stats is the snapshot returned by Info: the global counters, the number
+// of registered workers and the state of every known queue.
+type Stats struct {
+	Counters
+	Workers int          `json:"workers"`
+	Queues  []QueueStats `json:"queues"`
+	Time    time.Time    `json:"time"`
+}
+
+// QueueStats describes one queue. Pending, Delayed and Retry sum the jobs of
+// every priority, Processing the processing lists of every registered
+// worker, and Latency is how long the oldest pending job has been waiting
+// since it last became pending.
+type QueueStats struct {
+	Name       string `json:"name"`
+	Pending    int    `json:"pending"`
+	Processing int    `json:"processing"`
+	Delayed    int    `json:"delayed"`
+	Retry      int    `json:"retry"`
+	Dead       int    `json:"dead"`
+	Counters
+	Latency time.Duration        `json:"latency"`
+	Tasks   map[string]TaskStats `json:"tasks"`
+}
+
+// TaskStats counts the finished attempts of one task on a queue.
+type TaskStats struct {
+	Processed int `json:"processed"`
+	Failed    int `json:"failed"`
+}
+
+// Queue returns the stats of the named queue, or nil if it is unknown.
+func (s *Stats) Queue(name string) *QueueStats {
+	for i := range s.Queues {
+		if s.Queues[i].Name == name {
+			return &s.Queues[i]
+		}
+	}
+	return nil
+}
+
+// Info reports on every queue jobs have been enqueued to, plus those the
+// worker config lists.
+func (g *Gores) Info() (*Stats, error) {
+	conn := g.pool.Get()
+	defer conn.Close()
+
+	queues, err := g.knownQueues(conn)
+	if err != nil {
+		return nil, err
+	}
+	workers, err := redis.Strings(conn.Do("SMEMBERS", g.prefix+WORKERS))
+	if err != nil {
+		return nil, err
+	}
+
+	conn.Send("MULTI")
+	g.sendCounters(conn, "")
+	for _, q := range queues {
+		for p := 0; p <= MAX_JOB_PRIORITY; p++ {
+			pending := g.priorityKey(q, QUEUE_PENDING, p)
+			conn.Send("LLEN", pending)
+			conn.Send("ZCARD", g.priorityKey(q, QUEUE_DELAYED, p))
+			conn.Send("ZCARD", g.priorityKey(q, QUEUE_RETRY, p))
+			// Each pending list is in the order its jobs became pending,
+			// so its oldest job is the next one fetched.
+			conn.Send("LINDEX", pending, -1)
+		}
+		conn.Send("LLEN", g.queueKey(q, QUEUE_DEADLETTER))
+		conn.Send("SMEMBERS", g.prefix+TASKS+q)
+		g.sendCounters(conn, q)
+		for _, id := range workers {
+			conn.Send("LLEN", g.processingKey(q, id))
+		}
+	}
+	replies, err := redis.Values(conn.Do("EXEC"))
+	if err != nil {
+		return nil, err
+	}
+
+	now := time.Now()
+	stats := &Stats{Counters: parseCounters(replies), Workers: len(workers), Time: now}
+	replies = replies[len(counterNames):]
+	taskNames := make([][]string, len(queues))
+	for i, q := range queues {
+		qs := QueueStats{Name: q, Tasks: make(map[string]TaskStats)}
+		for p := 0; p <= MAX_JOB_PRIORITY; p++ {
+			pending, _ := redis.Int(replies[0], nil)
+			delayed, _ := redis.Int(replies[1], nil)
+			retry, _ := redis.Int(replies[2], nil)
+			qs.Pending, qs.Delayed, qs.Retry = qs.Pending+pending, qs.Delayed+delayed, qs.Retry+retry
+			if oldest, err := redis.Bytes(replies[3], nil); err == nil {
+				if job, err := FromBytes(oldest); err == nil {
+					qs.Latency = max(qs.Latency, sinceUnix(job.pendingSince(), now))
+					PutJob(job)
+				}
+			}
+			replies = replies[4:]
+		}
+		qs.Dead, _ = redis.Int(replies[0], nil)
+		taskNames[i], _ = redis.Strings(replies[1], nil)
+		replies = replies[2:]
+		qs.Counters = parseCounters(replies)
+		replies = replies[len(counterNames):]
+		for range workers {
+			n, _ := redis.Int(replies[0], nil)
+			qs.Processing += n
+			replies = replies[1:]
+		}
+		stats.Queues = append(stats.Queues, qs)
+	}
+
+	conn.Send("MULTI")
+	for i, q := range queues {
+		for _, task := range taskNames[i] {
+			conn.Send("GET", g.taskStatKey(STAT_PROCESSED, q, task))
+			conn.Send("GET", g.taskStatKey(STAT_FAILED, q, task))
+		}
+	}
+	replies, err = redis.Values(conn.Do("EXEC"))
+	if err != nil {
+		return nil, err
+	}
+	for i := range queues {
+		for _, task := range taskNames[i] {
+			var ts TaskStats
+			ts.Processed, _ = redis.Int(replies[0], nil)
+			ts.Failed, _ = redis.Int(replies[1], nil)
+			stats.Queues[i].Tasks[task] = ts
+			replies = replies[2:]
+		}
+	}
+	return stats, nil
+}
+
+// knownQueues returns the sorted union of the queues set and the
+// configured worker queues.
+func (g *Gores) knownQueues(conn redis.Conn) ([]string, error) {
+	queues, err := redis.Strings(conn.Do("SMEMBERS", g.prefix+QUEUES))
+	if err != nil {
+		return nil, err
+	}
+	if g.config != nil {
+		for _, q := range g.config.Worker.Queues {
+			found := false
+			for _, known := range queues {
+				found = found || known == q
+			}
+			if !found {
+				queues = append(queues, q)
+			}
+		}
+	}
+	sort.Strings(queues)
+	return queues, nil
+}
+
+// sinceUnix returns the time elapsed from Unix seconds t to now, or 0 if t
+// is unset or in the future.
+func sinceUnix(t float64, now time.Time) time.Duration {
+	if t == 0 {
+		return 0
+	}
+	return max(now.Sub(time.Unix(int64(t), 0)), 0)
+}
@@ -0,0 +1,135 @@
+package lib
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestInfoPerQueueAndTask(t *testing.T) {
+	cfg := newTestConfig()
+	g := NewGores(cfg)
+	defer g.Close()
+
+	const queue = "info_queue"
+	conn := g.pool.Get()
+	defer conn.Close()
+	_, _ = conn.Do("DEL", g.queueKey(queue, QUEUE_PENDING), g.queueKey(queue, QUEUE_DELAYED), g.queueKey(queue, QUEUE_RETRY),
+		g.queueKey(queue, QUEUE_DEADLETTER), g.processingKey(queue, testWorker), g.prefix+TASKS+queue)
+	for _, name := range counterNames {
+		_, _ = conn.Do("DEL", g.queueStatKey(name, queue))
+	}
+	for _, task := range []string{"Ok", "Flaky"} {
+		_, _ = conn.Do("DEL", g.taskStatKey(STAT_PROCESSED, queue, task), g.taskStatKey(STAT_FAILED, queue, task))
+	}
+	if err := g.register(conn, testWorker, 0, []string{queue}); err != nil {
+		t.Fatalf("register: %v", err)
+	}
+	defer g.deregister(conn, testWorker)
+
+	ctx := context.Background()
+	mux := NewServeMux()
+	mux.HandleFunc("Ok", func(ctx context.Context, job *Job) error { return nil })
+	mux.HandleFunc("Flaky", func(ctx context.Context, job *Job) error { return errors.New("boom") })
+	for _, name := range []string{"Ok", "Flaky"} {
+		if _, err := g.EnqueueJob(ctx, NewTask(name, nil), WithQueue(queue)); err != nil {
+			t.Fatalf("enqueue: %v", err)
+		}
+		_, data, _ := g.fetch(conn, testWorker, []string{queue})
+		g.handle(ctx, conn, WorkerConfig{}, testWorker, queue, data, mux)
+	}
+	old := NewTask("Ok", nil)
+	old.EnqueueTime = float64(time.Now().Add(-time.Minute).Unix())
+	for _, opts := range [][]Option{{WithQueue(queue)}, {WithQueue(queue), WithDelay(time.Hour)}} {
+		if _, err := g.EnqueueJob(ctx, old, opts...); err != nil {
+			t.Fatalf("enqueue: %v", err)
+		}
+		old = NewTask("Ok", nil)
+	}
+	_, _ = conn.Do("LPUSH", g.processingKey(queue, testWorker), "in-flight")
+
+	info, err := g.Info()
+	if err != nil {
+		t.Fatalf("Info: %v", err)
+	}
+	q := info.Queue(queue)
+	if q == nil {
+		t.Fatalf("expected %s in %+v", queue, info.Queues)
+	}
+	if q.Pending != 1 || q.Processing != 1 || q.Delayed != 1 || q.Retry != 1 || q.Dead != 0 {
+		t.Fatalf("unexpected sizes: %+v", q)
+	}
+	if q.Latency < 50*time.Second {
+		t.Fatalf("expected latency of about a minute, got %v", q.Latency)
+	}
+	if q.Enqueued != 4 || q.Succeeded != 1 || q.Retried != 1 {
+		t.Fatalf("unexpected counters: %+v", q.Counters)
+	}
+	if q.Tasks["Ok"] != (TaskStats{Processed: 1}) || q.Tasks["Flaky"] != (TaskStats{Processed: 1, Failed: 1}) {
+		t.Fatalf("unexpected task stats: %+v", q.Tasks)
+	}
+	if info.Workers < 1 || info.Processed < 2 {
+		t.Fatalf("unexpected global stats: %+v", info)
+	}
+}
+
+func TestInfoLatencyFindsOldestPendingJob(t *testing.T) {
+	cfg := newTestConfig()
+	g := NewGores(cfg)
+	defer g.Close()
+
+	const queue = "latency_queue"
+	conn := g.pool.Get()
+	defer conn.Close()
+	clearPending := func() {
+		for _, key := range g.priorityKeys(queue, QUEUE_PENDING) {
+			_, _ = conn.Do("DEL", key)
+		}
+	}
+	clearPending()
+	_, _ = conn.Do("DEL", g.processingKey(queue, testWorker))
+
+	ctx := context.Background()
+	stats := func() *QueueStats {
+		t.Helper()
+		info, err := g.Info()
+		if err != nil || info.Queue(queue) == nil {
+			t.Fatalf("Info: %v", err)
+		}
+		return info.Queue(queue)
+	}
+
+	// A job enqueued long ago but requeued just now has only just started waiting.
+	stale := NewTask("Ok", nil)
+	stale.EnqueueTime = float64(time.Now().Add(-time.Hour).Unix())
+	if _, err := g.EnqueueJob(ctx, stale, WithQueue(queue)); err != nil {
+		t.Fatalf("enqueue: %v", err)
+	}
+	_, data, _ := g.fetch(conn, testWorker, []string{queue})
+	if err := g.requeue(conn, testWorker, queue, data); err != nil {
+		t.Fatalf("requeue: %v", err)
+	}
+	if l := stats().Latency; l > 5*time.Second {
+		t.Fatalf("expected latency from the requeue, got %v", l)
+	}
+
+	// The oldest job waits behind jobs of higher priority.
+	old := NewTask("Ok", nil)
+	old.EnqueueTime = float64(time.Now().Add(-2 * time.Minute).Unix())
+	clearPending()
+	if _, err := g.EnqueueJob(ctx, old, WithQueue(queue)); err != nil {
+		t.Fatalf("enqueue: %v", err)
+	}
+	for _, p := range []int{3, 3, MAX_JOB_PRIORITY} {
+		if _, err := g.EnqueueJob(ctx, NewTask("Ok", nil), WithQueue(queue), WithPriority(p)); err != nil {
+			t.Fatalf("enqueue urgent: %v", err)
+		}
+	}
+	if l := stats().Latency; l < 110*time.Second || l > 130*time.Second {
+		t.Fatalf("expected latency of about two minutes, got %v", l)
+	}
+	if q := stats(); q.Pending != 4 {
+		t.Fatalf("expected pending to count every priority, got %d", q.Pending)
+	}
+}
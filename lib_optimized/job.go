@@ -1,13 +1,28 @@
 package lib
 
 import (
+	"errors"
 	"fmt"
+	"math"
 	"sync"
+	"time"
 
 	"github.com/vmihailenco/msgpack/v5"
 )
 
+// JOB_VERSION is the encoding version stamped on enqueued jobs. Jobs
+// without a version predate versioning and count as version 1.
+const JOB_VERSION = 1
+
+// ErrInvalidJob is matched by every job validation error.
+var ErrInvalidJob = errors.New("invalid job")
+
+// ErrUnknownVersion is matched by the error FromBytes returns for a job
+// encoded with a newer JOB_VERSION than this build understands.
+var ErrUnknownVersion = errors.New("unknown job version")
+
 type Job struct {
+	Version     int                    `msgpack:"v,omitempty"`
 	ID          string                 `msgpack:"id"`
 	Name        string                 `msgpack:"name"`
 	Queue       string                 `msgpack:"queue"`
@@ -15,6 +30,40 @@ type Job struct {
 	Retry       bool                   `msgpack:"retry"`
 	RetryCount  int                    `msgpack:"retry_count"`
 	EnqueueTime float64                `msgpack:"enqueue_time"`
+
+	// PendingAt is when the job last joined, or is due to join, its
+	// pending list, in Unix seconds; queue latency is measured from it.
+	PendingAt float64 `msgpack:"pending_at,omitempty"`
+
+	// Retry policy. Zero values fall back to DEFAULT_MAX_RETRIES,
+	// BACKOFF_EXPONENTIAL and DEFAULT_MAX_BACKOFF. MaxRetries counts
+	// retries, not attempts: a job runs at most MaxRetries+1 times.
+	MaxRetries int           `msgpack:"max_retries,omitempty"`
+	Backoff    string        `msgpack:"backoff,omitempty"`
+	MaxBackoff time.Duration `msgpack:"max_backoff,omitempty"`
+
+	// Recoveries counts how often the job was rescued from a dead worker.
+	Recoveries int `msgpack:"recoveries,omitempty"`
+
+	// Timeout bounds a single attempt; zero defers to the worker config.
+	Timeout time.Duration `msgpack:"timeout,omitempty"`
+
+	// Payload holds a typed payload encoded with the codec named by Codec.
+	Payload []byte `msgpack:"payload,omitempty"`
+	Codec   string `msgpack:"codec,omitempty"`
+
+	// Enqueue options; see Option. RunAt and Deadline are Unix seconds.
+	RunAt     float64       `msgpack:"run_at,omitempty"`
+	Deadline  float64       `msgpack:"deadline,omitempty"`
+	UniqueKey string        `msgpack:"unique_key,omitempty"`
+	UniqueTTL time.Duration `msgpack:"unique_ttl,omitempty"`
+	Priority  int           `msgpack:"priority,omitempty"`
+
+	// Failure record of a dead-lettered job. FailedAt is Unix seconds and
+	// Attempts counts the runs that ended in failure or a lost worker.
+	LastError string  `msgpack:"last_error,omitempty"`
+	FailedAt  float64 `msgpack:"failed_at,omitempty"`
+	Attempts  int     `msgpack:"attempts,omitempty"`
 }
 
 var jobPool = sync.Pool{
@@ -23,16 +72,34 @@ var jobPool = sync.Pool{
 	},
 }
 
+// NewTask returns a retryable job for task name on DEFAULT_QUEUE, ready to
+// be passed to EnqueueJob. The args map is copied.
+func NewTask(name string, args map[string]interface{}) *Job {
+	job := &Job{Name: name, Queue: DEFAULT_QUEUE, Retry: true, Args: make(map[string]interface{}, len(args))}
+	for k, v := range args {
+		job.Args[k] = v
+	}
+	return job
+}
+
 func GetJob() *Job {
 	return jobPool.Get().(*Job)
 }
 
 func PutJob(j *Job) {
-	j.ID, j.Name, j.Queue = "", "", ""
+	j.Version, j.ID, j.Name, j.Queue = 0, "", "", ""
+	if j.Args == nil {
+		// Decoding a job with nil args leaves the map nil.
+		j.Args = make(map[string]interface{}, 8)
+	}
 	for k := range j.Args {
 		delete(j.Args, k)
 	}
-	j.Retry, j.RetryCount = false, 0
+	j.Retry, j.RetryCount, j.EnqueueTime, j.PendingAt = false, 0, 0, 0
+	j.MaxRetries, j.Backoff, j.MaxBackoff = 0, "", 0
+	j.Recoveries, j.Timeout, j.Payload, j.Codec = 0, 0, nil, ""
+	j.RunAt, j.Deadline, j.UniqueKey, j.UniqueTTL, j.Priority = 0, 0, "", 0, 0
+	j.LastError, j.FailedAt, j.Attempts = "", 0, 0
 	jobPool.Put(j)
 }
 
@@ -46,12 +113,138 @@ func FromBytes(data []byte) (*Job, error) {
 		PutJob(j)
 		return nil, fmt.Errorf("msgpack: %w", err)
 	}
+	if j.Version > JOB_VERSION {
+		v := j.Version
+		PutJob(j)
+		return nil, fmt.Errorf("%w: %d", ErrUnknownVersion, v)
+	}
 	return j, nil
 }
 
+// pendingSince returns when the job last became pending. Jobs encoded
+// before PendingAt was recorded fall back to their enqueue or run time.
+func (j *Job) pendingSince() float64 {
+	if j.PendingAt > 0 {
+		return j.PendingAt
+	}
+	return max(j.EnqueueTime, j.RunAt)
+}
+
+// rejoin records that the job rejoins the back of its pending list at t.
+func (j *Job) rejoin(t time.Time) {
+	j.PendingAt = float64(t.Unix())
+}
+
+// markFailed records why and when the job was dead-lettered.
+func (j *Job) markFailed(reason string, attempts int, now time.Time) {
+	j.LastError, j.FailedAt, j.Attempts = reason, float64(now.Unix()), attempts
+}
+
+// scheduled reports whether the job should wait on the delayed set.
+func (j *Job) scheduled() bool {
+	return j.RunAt > float64(time.Now().Unix())
+}
+
+// expired reports whether the job's deadline has passed at now.
+func (j *Job) expired(now time.Time) bool {
+	return j.Deadline > 0 && float64(now.Unix()) >= j.Deadline
+}
+
+// priority returns the job's priority clamped to 0..MAX_JOB_PRIORITY.
+func (j *Job) priority() int {
+	return min(max(j.Priority, 0), MAX_JOB_PRIORITY)
+}
+
 func (j *Job) Validate() error {
 	if j.Name == "" || j.Queue == "" {
-		return fmt.Errorf("name/queue empty")
+		return fmt.Errorf("%w: name/queue empty", ErrInvalidJob)
+	}
+	if j.MaxRetries < 0 || j.MaxBackoff < 0 || j.Timeout < 0 || j.UniqueTTL < 0 {
+		return fmt.Errorf("%w: negative retry policy", ErrInvalidJob)
+	}
+	switch j.Backoff {
+	case "", BACKOFF_CONSTANT, BACKOFF_LINEAR, BACKOFF_EXPONENTIAL:
+	default:
+		return fmt.Errorf("%w: unknown backoff %q", ErrInvalidJob, j.Backoff)
 	}
 	return nil
 }
+
+// jobFromMap builds a pooled job from the map form accepted by Enqueue.
+// Name and Queue must be strings; Args, Retry and the retry policy keys
+// are optional but must have the right type when present. Numeric keys
+// also accept whole float64 values, as maps decoded from JSON hold them;
+// a numeric MaxBackoff is in nanoseconds, like a JSON-encoded Duration.
+func jobFromMap(jobData map[string]interface{}) (*Job, error) {
+	job := GetJob()
+	fail := func(key, want string) (*Job, error) {
+		PutJob(job)
+		return nil, fmt.Errorf("%w: %s must be %s, got %T", ErrInvalidJob, key, want, jobData[key])
+	}
+
+	var ok bool
+	if job.Name, ok = jobData["Name"].(string); !ok {
+		return fail("Name", "a string")
+	}
+	if job.Queue, ok = jobData["Queue"].(string); !ok {
+		return fail("Queue", "a string")
+	}
+	if v, present := jobData["Args"]; present && v != nil {
+		args, ok := v.(map[string]interface{})
+		if !ok {
+			return fail("Args", "a map[string]interface{}")
+		}
+		for k, v := range args {
+			job.Args[k] = v
+		}
+	}
+	if v, present := jobData["Retry"]; present {
+		if job.Retry, ok = v.(bool); !ok {
+			return fail("Retry", "a bool")
+		}
+	}
+	if v, present := jobData["MaxRetries"]; present {
+		n, ok := wholeNumber(v)
+		if !ok {
+			return fail("MaxRetries", "an int")
+		}
+		job.MaxRetries = int(n)
+	}
+	if v, present := jobData["Backoff"]; present {
+		if job.Backoff, ok = v.(string); !ok {
+			return fail("Backoff", "a string")
+		}
+	}
+	if v, present := jobData["MaxBackoff"]; present {
+		d, ok := v.(time.Duration)
+		if !ok {
+			n, whole := wholeNumber(v)
+			if !whole {
+				return fail("MaxBackoff", "a time.Duration")
+			}
+			d = time.Duration(n)
+		}
+		job.MaxBackoff = d
+	}
+	if v, present := jobData["ID"]; present {
+		if job.ID, ok = v.(string); !ok {
+			return fail("ID", "a string")
+		}
+	}
+	return job, nil
+}
+
+// wholeNumber returns v as an int64 if it is an int or a float64 without a
+// fractional part.
+func wholeNumber(v interface{}) (int64, bool) {
+	switch n := v.(type) {
+	case int:
+		return int64(n), true
+	case float64:
+		if n != math.Trunc(n) || math.Abs(n) > 1<<53 {
+			return 0, false
+		}
+		return int64(n), true
+	}
+	return 0, false
+}
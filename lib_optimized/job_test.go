@@ -1,7 +1,9 @@
 package lib
 
 import (
+	"errors"
 	"testing"
+	"time"
 )
 
 func TestMsgpackRoundTrip(t *testing.T) {
@@ -63,3 +65,69 @@ func TestFromBytesInvalidData(t *testing.T) {
 		t.Fatal("expected unmarshal error")
 	}
 }
+
+func TestJobValidateRetryPolicy(t *testing.T) {
+	bad := []*Job{
+		{Name: "n", Queue: "q", MaxRetries: -1},
+		{Name: "n", Queue: "q", Backoff: "fibonacci"},
+	}
+	for _, j := range bad {
+		if err := j.Validate(); err == nil {
+			t.Errorf("expected error for %+v", j)
+		}
+	}
+	ok := &Job{Name: "n", Queue: "q", Backoff: BACKOFF_LINEAR, MaxRetries: 5}
+	if err := ok.Validate(); err != nil {
+		t.Errorf("unexpected error: %v", err)
+	}
+}
+
+func TestNewTask(t *testing.T) {
+	args := map[string]interface{}{"id": float64(1)}
+	j := NewTask("PrintJob", args)
+	args["id"] = float64(2)
+	if j.Name != "PrintJob" || j.Queue != DEFAULT_QUEUE || !j.Retry {
+		t.Fatalf("unexpected defaults %+v", j)
+	}
+	if j.Args["id"] != float64(1) {
+		t.Fatalf("expected args to be copied, got %v", j.Args)
+	}
+}
+
+func TestJobFromMap(t *testing.T) {
+	j, err := jobFromMap(map[string]interface{}{
+		"Name":       "PrintJob",
+		"Queue":      "demo_queue",
+		"Args":       map[string]interface{}{"id": float64(1)},
+		"MaxRetries": 5,
+	})
+	if err != nil {
+		t.Fatalf("jobFromMap: %v", err)
+	}
+	defer PutJob(j)
+	if j.Name != "PrintJob" || j.Retry || j.MaxRetries != 5 || j.Args["id"] != float64(1) {
+		t.Fatalf("unexpected job %+v", j)
+	}
+
+	if _, err := jobFromMap(map[string]interface{}{"Name": "PrintJob"}); !errors.Is(err, ErrInvalidJob) {
+		t.Fatalf("expected ErrInvalidJob for missing queue, got %v", err)
+	}
+
+	// Maps decoded from JSON carry numbers as float64.
+	j2, err := jobFromMap(map[string]interface{}{
+		"Name":       "PrintJob",
+		"Queue":      "demo_queue",
+		"MaxRetries": float64(2),
+		"MaxBackoff": float64(time.Minute),
+	})
+	if err != nil {
+		t.Fatalf("jobFromMap with float64 policy: %v", err)
+	}
+	defer PutJob(j2)
+	if j2.MaxRetries != 2 || j2.MaxBackoff != time.Minute {
+		t.Fatalf("unexpected policy %d, %v", j2.MaxRetries, j2.MaxBackoff)
+	}
+	if _, err := jobFromMap(map[string]interface{}{"Name": "PrintJob", "Queue": "q", "MaxRetries": 2.5}); !errors.Is(err, ErrInvalidJob) {
+		t.Fatalf("expected ErrInvalidJob for fractional MaxRetries, got %v", err)
+	}
+}
@@ -0,0 +1,235 @@
+package lib
+
+import (
+	"bufio"
+	"fmt"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+const (
+	METRICS_PATH         = "/metrics"
+	METRICS_CONTENT_TYPE = "text/plain; version=0.0.4; charset=utf-8"
+
+	OUTCOME_SUCCEEDED   = "succeeded"
+	OUTCOME_FAILED      = "failed"
+	OUTCOME_CANCELLED   = "cancelled"
+	OUTCOME_INTERRUPTED = "interrupted"
+	OUTCOME_POISONED    = "poisoned"
+)
+
+// DURATION_BUCKETS are the upper bounds, in seconds, of the job duration
+// histogram.
+var DURATION_BUCKETS = []float64{0.005, 0.01, 0.025, 0.05, 0.1, 0.25, 0.5, 1, 2.5, 5, 10, 30, 60, 300}
+
+type taskLabels struct {
+	queue, task string
+}
+
+type outcomeLabels struct {
+	queue, task, outcome string
+}
+
+type histogram struct {
+	buckets []uint64 // cumulative counts per DURATION_BUCKETS bound
+	count   uint64
+	sum     float64
+}
+
+// metrics collects the job counters and durations seen by this process.
+// Queue gauges are read from Redis when metrics are scraped.
+type metrics struct {
+	mu        sync.Mutex
+	enqueued  map[taskLabels]uint64
+	processed map[outcomeLabels]uint64
+	durations map[taskLabels]*histogram
+	busy      atomic.Int64
+}
+
+func newMetrics() *metrics {
+	return &metrics{
+		enqueued:  make(map[taskLabels]uint64),
+		processed: make(map[outcomeLabels]uint64),
+		durations: make(map[taskLabels]*histogram),
+	}
+}
+
+func (m *metrics) jobEnqueued(job *Job) {
+	m.mu.Lock()
+	m.enqueued[taskLabels{job.Queue, job.Name}]++
+	m.mu.Unlock()
+}
+
+// jobFinished records one attempt of job that ran for d.
+func (m *metrics) jobFinished(job *Job, outcome string, d time.Duration) {
+	l := taskLabels{job.Queue, job.Name}
+	secs := d.Seconds()
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.processed[outcomeLabels{job.Queue, job.Name, outcome}]++
+	h := m.durations[l]
+	if h == nil {
+		h = &histogram{buckets: make([]uint64, len(DURATION_BUCKETS))}
+		m.durations[l] = h
+	}
+	for i, bound := range DURATION_BUCKETS {
+		if secs <= bound {
+			h.buckets[i]++
+		}
+	}
+	h.count++
+	h.sum += secs
+}
+
+// jobPoisoned records a fetched job of queue that could not be decoded and
+// was quarantined. Its task is unknown, so it is counted under an empty one.
+func (m *metrics) jobPoisoned(queue string) {
+	m.mu.Lock()
+	m.processed[outcomeLabels{queue, "", OUTCOME_POISONED}]++
+	m.mu.Unlock()
+}
+
+// metricsSnapshot is a copy of the counters and histograms of metrics.
+type metricsSnapshot struct {
+	enqueued  map[taskLabels]uint64
+	processed map[outcomeLabels]uint64
+	durations map[taskLabels]histogram
+}
+
+// snapshot copies m's counters and histograms, so they can be written out
+// without holding up the workers updating them.
+func (m *metrics) snapshot() metricsSnapshot {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	s := metricsSnapshot{
+		enqueued:  make(map[taskLabels]uint64, len(m.enqueued)),
+		processed: make(map[outcomeLabels]uint64, len(m.processed)),
+		durations: make(map[taskLabels]histogram, len(m.durations)),
+	}
+	for l, n := range m.enqueued {
+		s.enqueued[l] = n
+	}
+	for l, n := range m.processed {
+		s.processed[l] = n
+	}
+	for l, h := range m.durations {
+		s.durations[l] = histogram{buckets: append([]uint64(nil), h.buckets...), count: h.count, sum: h.sum}
+	}
+	return s
+}
+
+// MetricsHandler serves the metrics of g in the Prometheus text format:
+// job counters and durations seen by this process, queue sizes and
+// latencies, worker counts and Redis pool statistics.
+func (g *Gores) MetricsHandler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		stats, err := g.Info()
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusServiceUnavailable)
+			return
+		}
+		w.Header().Set("Content-Type", METRICS_CONTENT_TYPE)
+		bw := bufio.NewWriter(w)
+		g.writeMetrics(bw, stats)
+		bw.Flush()
+	})
+}
+
+// ServeMetrics serves MetricsHandler on addr at METRICS_PATH until the
+// server fails.
+func (g *Gores) ServeMetrics(addr string) error {
+	mux := http.NewServeMux()
+	mux.Handle(METRICS_PATH, g.MetricsHandler())
+	return http.ListenAndServe(addr, mux)
+}
+
+func (g *Gores) writeMetrics(w *bufio.Writer, stats *Stats) {
+	m := g.metrics.snapshot()
+	writeHeader(w, "gores_jobs_enqueued_total", "counter", "Jobs enqueued by this process.")
+	for _, l := range sortedKeys(m.enqueued, func(l taskLabels) string { return l.queue + "\x00" + l.task }) {
+		writeSample(w, "gores_jobs_enqueued_total", labels("queue", l.queue, "task", l.task), float64(m.enqueued[l]))
+	}
+	writeHeader(w, "gores_jobs_processed_total", "counter", "Job attempts finished by this process.")
+	for _, l := range sortedKeys(m.processed, func(l outcomeLabels) string { return l.queue + "\x00" + l.task + "\x00" + l.outcome }) {
+		writeSample(w, "gores_jobs_processed_total", labels("queue", l.queue, "task", l.task, "outcome", l.outcome), float64(m.processed[l]))
+	}
+	writeHeader(w, "gores_job_duration_seconds", "histogram", "Run time of job attempts finished by this process.")
+	for _, l := range sortedKeys(m.durations, func(l taskLabels) string { return l.queue + "\x00" + l.task }) {
+		h := m.durations[l]
+		for i, bound := range DURATION_BUCKETS {
+			le := strconv.FormatFloat(bound, 'g', -1, 64)
+			writeSample(w, "gores_job_duration_seconds_bucket", labels("queue", l.queue, "task", l.task, "le", le), float64(h.buckets[i]))
+		}
+		writeSample(w, "gores_job_duration_seconds_bucket", labels("queue", l.queue, "task", l.task, "le", "+Inf"), float64(h.count))
+		writeSample(w, "gores_job_duration_seconds_sum", labels("queue", l.queue, "task", l.task), h.sum)
+		writeSample(w, "gores_job_duration_seconds_count", labels("queue", l.queue, "task", l.task), float64(h.count))
+	}
+
+	writeHeader(w, "gores_queue_jobs", "gauge", "Jobs on each of a queue's lists.")
+	for _, q := range stats.Queues {
+		for _, list := range []struct {
+			name string
+			n    int
+		}{{"pending", q.Pending}, {"processing", q.Processing}, {"delayed", q.Delayed}, {"retry", q.Retry}, {"dead", q.Dead}} {
+			writeSample(w, "gores_queue_jobs", labels("queue", q.Name, "list", list.name), float64(list.n))
+		}
+	}
+	writeHeader(w, "gores_queue_latency_seconds", "gauge", "Age of the oldest pending job.")
+	for _, q := range stats.Queues {
+		writeSample(w, "gores_queue_latency_seconds", labels("queue", q.Name), q.Latency.Seconds())
+	}
+
+	writeHeader(w, "gores_workers_registered", "gauge", "Workers registered across all processes.")
+	writeSample(w, "gores_workers_registered", "", float64(stats.Workers))
+	writeHeader(w, "gores_workers_busy", "gauge", "Workers of this process running a job.")
+	writeSample(w, "gores_workers_busy", "", float64(g.metrics.busy.Load()))
+
+	pool := g.pool.Stats()
+	writeHeader(w, "gores_redis_pool_active_connections", "gauge", "Redis connections in the pool, idle or in use.")
+	writeSample(w, "gores_redis_pool_active_connections", "", float64(pool.ActiveCount))
+	writeHeader(w, "gores_redis_pool_idle_connections", "gauge", "Idle Redis connections in the pool.")
+	writeSample(w, "gores_redis_pool_idle_connections", "", float64(pool.IdleCount))
+}
+
+func writeHeader(w *bufio.Writer, name, kind, help string) {
+	fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s %s\n", name, help, name, kind)
+}
+
+func writeSample(w *bufio.Writer, name, labels string, v float64) {
+	fmt.Fprintf(w, "%s%s %s\n", name, labels, strconv.FormatFloat(v, 'g', -1, 64))
+}
+
+var labelEscaper = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`)
+
+// labels formats name/value pairs as a Prometheus label set.
+func labels(pairs ...string) string {
+	var b strings.Builder
+	b.WriteByte('{')
+	for i := 0; i < len(pairs); i += 2 {
+		if i > 0 {
+			b.WriteByte(',')
+		}
+		b.WriteString(pairs[i])
+		b.WriteString(`="`)
+		labelEscaper.WriteString(&b, pairs[i+1])
+		b.WriteByte('"')
+	}
+	b.WriteByte('}')
+	return b.String()
+}
+
+// sortedKeys returns the keys of m ordered by key, for stable output.
+func sortedKeys[K comparable, V any](m map[K]V, key func(K) string) []K {
+	keys := make([]K, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Slice(keys, func(i, j int) bool { return key(keys[i]) < key(keys[j]) })
+	return keys
+}
@@ -0,0 +1,117 @@
+package lib
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestMetricsHandler(t *testing.T) {
+	cfg := newTestConfig()
+	g := NewGores(cfg)
+	defer g.Close()
+
+	const queue = "metrics_queue"
+	conn := g.pool.Get()
+	defer conn.Close()
+	_, _ = conn.Do("DEL", g.queueKey(queue, QUEUE_PENDING), g.queueKey(queue, QUEUE_RETRY))
+
+	ctx := context.Background()
+	mux := NewServeMux()
+	mux.HandleFunc("Ok", func(ctx context.Context, job *Job) error { return nil })
+	mux.HandleFunc("Flaky", func(ctx context.Context, job *Job) error { return errors.New("boom") })
+	for _, name := range []string{"Ok", "Flaky", "Ok"} {
+		if _, err := g.EnqueueJob(ctx, NewTask(name, nil), WithQueue(queue)); err != nil {
+			t.Fatalf("enqueue: %v", err)
+		}
+	}
+	for i := 0; i < 2; i++ {
+		_, data, _ := g.fetch(conn, testWorker, []string{queue})
+		g.handle(ctx, conn, WorkerConfig{}, testWorker, queue, data, mux)
+	}
+	_, _ = conn.Do("RPUSH", g.queueKey(queue, QUEUE_PENDING), "garbage")
+	_, data, _ := g.fetch(conn, testWorker, []string{queue})
+	g.handle(ctx, conn, WorkerConfig{}, testWorker, queue, data, mux)
+
+	rec := httptest.NewRecorder()
+	g.MetricsHandler().ServeHTTP(rec, httptest.NewRequest("GET", METRICS_PATH, nil))
+	if rec.Code != 200 || rec.Header().Get("Content-Type") != METRICS_CONTENT_TYPE {
+		t.Fatalf("unexpected response %d %q", rec.Code, rec.Header().Get("Content-Type"))
+	}
+	body := rec.Body.String()
+	for _, want := range []string{
+		`gores_jobs_enqueued_total{queue="metrics_queue",task="Ok"} 2`,
+		`gores_jobs_processed_total{queue="metrics_queue",task="Flaky",outcome="failed"} 1`,
+		`gores_jobs_processed_total{queue="metrics_queue",task="Ok",outcome="succeeded"} 1`,
+		`gores_jobs_processed_total{queue="metrics_queue",task="",outcome="poisoned"} 1`,
+		`gores_job_duration_seconds_bucket{queue="metrics_queue",task="Ok",le="+Inf"} 1`,
+		`gores_job_duration_seconds_count{queue="metrics_queue",task="Flaky"} 1`,
+		`gores_queue_jobs{queue="metrics_queue",list="pending"} 1`,
+		`gores_queue_jobs{queue="metrics_queue",list="retry"} 1`,
+		`gores_queue_latency_seconds{queue="metrics_queue"} `,
+		"# TYPE gores_job_duration_seconds histogram",
+		"gores_workers_busy 0",
+		"gores_redis_pool_active_connections ",
+	} {
+		if !strings.Contains(body, want) {
+			t.Errorf("metrics missing %q", want)
+		}
+	}
+}
+
+func TestLabelsEscaping(t *testing.T) {
+	if got := labels("task", "a\"b\\c\nd"); got != `{task="a\"b\\c\nd"}` {
+		t.Fatalf("unexpected label set %s", got)
+	}
+}
+
+// stalledWriter is a ResponseWriter whose body writes block until released.
+type stalledWriter struct {
+	*httptest.ResponseRecorder
+	writing chan struct{}
+	release chan struct{}
+}
+
+func (w *stalledWriter) Write(p []byte) (int, error) {
+	select {
+	case w.writing <- struct{}{}:
+	default:
+	}
+	<-w.release
+	return w.ResponseRecorder.Write(p)
+}
+
+func TestSlowScrapeDoesNotBlockJobs(t *testing.T) {
+	cfg := newTestConfig()
+	g := NewGores(cfg)
+	defer g.Close()
+
+	// Enough series to overflow the handler's write buffer.
+	for i := 0; i < 200; i++ {
+		g.metrics.jobFinished(&Job{Queue: "metrics_queue", Name: fmt.Sprintf("Task%d", i)}, OUTCOME_SUCCEEDED, time.Millisecond)
+	}
+	w := &stalledWriter{ResponseRecorder: httptest.NewRecorder(), writing: make(chan struct{}, 1), release: make(chan struct{})}
+	scraped := make(chan struct{})
+	go func() {
+		g.MetricsHandler().ServeHTTP(w, httptest.NewRequest("GET", METRICS_PATH, nil))
+		close(scraped)
+	}()
+	<-w.writing
+
+	finished := make(chan struct{})
+	go func() {
+		g.metrics.jobFinished(&Job{Queue: "metrics_queue", Name: "Task0"}, OUTCOME_SUCCEEDED, time.Millisecond)
+		close(finished)
+	}()
+	select {
+	case <-finished:
+	case <-time.After(time.Second):
+		t.Error("jobFinished blocked behind a stalled scrape")
+	}
+	close(w.release)
+	<-scraped
+}
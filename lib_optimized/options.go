@@ -0,0 +1,70 @@
+package lib
+
+import "time"
+
+// Option customizes a job at enqueue time. Every option is recorded on the
+// Job itself so workers can enforce it.
+type Option func(*Job)
+
+// WithID enqueues the job under id instead of a generated one. Enqueueing
+// an ID whose status record still exists fails with ErrDuplicateJob, which
+// makes retried enqueues idempotent.
+func WithID(id string) Option {
+	return func(j *Job) { j.ID = id }
+}
+
+// WithQueue sets the queue the job is pushed to.
+func WithQueue(queue string) Option {
+	return func(j *Job) { j.Queue = queue }
+}
+
+// WithDelay holds the job on its queue's delayed set until d has elapsed.
+func WithDelay(d time.Duration) Option {
+	return func(j *Job) { j.RunAt = float64(time.Now().Add(d).Unix()) }
+}
+
+// WithDeadline fails the job permanently, without running or retrying it,
+// once t has passed. A running attempt's context is cancelled at t.
+func WithDeadline(t time.Time) Option {
+	return func(j *Job) { j.Deadline = float64(t.Unix()) }
+}
+
+// WithMaxRetries sets how many times the job may be retried, so it runs at
+// most n+1 times; 0 disables retries.
+func WithMaxRetries(n int) Option {
+	return func(j *Job) {
+		j.MaxRetries = n
+		j.Retry = n > 0
+	}
+}
+
+// WithTimeout bounds each attempt of the job to d.
+func WithTimeout(d time.Duration) Option {
+	return func(j *Job) { j.Timeout = d }
+}
+
+// WithUniqueKey makes the job unique under key: enqueueing another job with
+// the same key fails with ErrDuplicateJob until this one succeeds, is
+// dead-lettered or its lock expires (DEFAULT_UNIQUE_TTL unless WithUnique
+// sets a TTL).
+func WithUniqueKey(key string) Option {
+	return func(j *Job) { j.UniqueKey = key }
+}
+
+// WithUnique makes the job unique for up to ttl, keyed by WithUniqueKey or,
+// without one, by a hash of its name, queue and args.
+func WithUnique(ttl time.Duration) Option {
+	return func(j *Job) { j.UniqueTTL = ttl }
+}
+
+// MAX_JOB_PRIORITY is the highest priority WithPriority accepts.
+const MAX_JOB_PRIORITY = 9
+
+// WithPriority sets the job's priority, from 0, the default, to
+// MAX_JOB_PRIORITY; values outside that range are clamped. A worker fetches
+// a queue's jobs of higher priority before any of lower priority, and jobs
+// of equal priority in the order they became pending. The priority holds
+// for the job's whole life, including delays, retries and recoveries.
+func WithPriority(p int) Option {
+	return func(j *Job) { j.Priority = min(max(p, 0), MAX_JOB_PRIORITY) }
+}
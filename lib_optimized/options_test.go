@@ -0,0 +1,137 @@
+package lib
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/garyburd/redigo/redis"
+)
+
+func TestOptionsAreStoredOnJob(t *testing.T) {
+	deadline := time.Now().Add(time.Hour)
+	job := NewTask("PrintJob", nil)
+	for _, opt := range []Option{
+		WithQueue("reports"),
+		WithDelay(time.Minute),
+		WithDeadline(deadline),
+		WithMaxRetries(0),
+		WithTimeout(5 * time.Second),
+		WithUniqueKey("report:42"),
+		WithPriority(3),
+	} {
+		opt(job)
+	}
+
+	if job.Queue != "reports" || job.Retry || job.Timeout != 5*time.Second ||
+		job.UniqueKey != "report:42" || job.Priority != 3 || int64(job.Deadline) != deadline.Unix() {
+		t.Fatalf("options not applied: %+v", job)
+	}
+	if WithPriority(100)(job); job.Priority != MAX_JOB_PRIORITY {
+		t.Fatalf("expected priority clamped to %d, got %d", MAX_JOB_PRIORITY, job.Priority)
+	}
+	if !job.scheduled() {
+		t.Fatal("expected WithDelay to schedule the job")
+	}
+
+	data, _ := job.ToBytes()
+	decoded, err := FromBytes(data)
+	if err != nil {
+		t.Fatalf("unmarshal: %v", err)
+	}
+	defer PutJob(decoded)
+	if decoded.UniqueKey != "report:42" || decoded.Deadline != job.Deadline || decoded.RunAt != job.RunAt || decoded.Priority != job.Priority {
+		t.Fatalf("options lost in serialization: %+v", decoded)
+	}
+}
+
+func TestEnqueueJobOptions(t *testing.T) {
+	cfg := newTestConfig()
+	g := NewGores(cfg)
+	defer g.Close()
+
+	const queue = "options_queue"
+	conn := g.pool.Get()
+	defer conn.Close()
+	for _, set := range []string{QUEUE_PENDING, QUEUE_DELAYED, QUEUE_RETRY} {
+		for _, key := range g.priorityKeys(queue, set) {
+			_, _ = conn.Do("DEL", key)
+		}
+	}
+
+	ctx := context.Background()
+	enqueue := func(priority int, opts ...Option) string {
+		t.Helper()
+		id, err := g.EnqueueJob(ctx, NewTask("PrintJob", nil), append(opts, WithQueue(queue), WithPriority(priority))...)
+		if err != nil {
+			t.Fatalf("enqueue: %v", err)
+		}
+		return id
+	}
+	normal := enqueue(0)
+	high := enqueue(5)
+	medium := enqueue(2)
+	high2 := enqueue(5)
+	// A delayed job keeps its priority once it is due.
+	urgent := enqueue(7, WithDelay(time.Hour))
+	if _, err := EnqueueTyped(ctx, g, "PrintJob", printArgs{ID: 1}, WithQueue(queue), WithDelay(time.Hour)); err != nil {
+		t.Fatalf("enqueue delayed: %v", err)
+	}
+
+	if n, _ := redis.Int(conn.Do("ZCARD", g.queueKey(queue, QUEUE_DELAYED))); n != 1 {
+		t.Fatalf("expected 1 delayed job of default priority, got %d", n)
+	}
+	if n, err := g.forward(conn, queue, time.Now().Add(30*time.Minute)); err != nil || n != 0 {
+		t.Fatalf("expected nothing due yet, got %d (%v)", n, err)
+	}
+	if n, err := g.forward(conn, queue, time.Now().Add(2*time.Hour)); err != nil || n != 2 {
+		t.Fatalf("expected 2 jobs forwarded, got %d (%v)", n, err)
+	}
+	for _, want := range []string{urgent, high, high2, medium, normal} {
+		_, data, err := g.fetch(conn, testWorker, []string{queue})
+		if err != nil || data == nil {
+			t.Fatalf("fetch: %v", err)
+		}
+		next, _ := FromBytes(data)
+		if next.ID != want {
+			t.Fatalf("expected %s to be fetched next, got %s", want, next.ID)
+		}
+		PutJob(next)
+	}
+	_, _ = conn.Do("DEL", g.processingKey(queue, testWorker))
+
+	// So does a retried one.
+	enqueue(4)
+	_, data, _ := g.fetch(conn, testWorker, []string{queue})
+	if err := g.retryOrBury(conn, testWorker, queue, data, errors.New("boom")); err != nil {
+		t.Fatalf("retryOrBury: %v", err)
+	}
+	if n, _ := redis.Int(conn.Do("ZCARD", g.priorityKey(queue, QUEUE_RETRY, 4))); n != 1 {
+		t.Fatalf("expected the retry to keep priority 4, got %d jobs on its retry set", n)
+	}
+}
+
+func TestProcessJobRejectsExpiredJob(t *testing.T) {
+	cfg := newTestConfig()
+	g := NewGores(cfg)
+	defer g.Close()
+
+	j := NewTask("PrintJob", nil)
+	WithDeadline(time.Now().Add(-time.Second))(j)
+	data, _ := j.ToBytes()
+
+	ran := false
+	mux := NewServeMux()
+	mux.HandleFunc("PrintJob", func(ctx context.Context, job *Job) error {
+		ran = true
+		return nil
+	})
+	err := processData(g, context.Background(), WorkerConfig{}, data, mux)
+	if !errors.Is(err, ErrJobExpired) || IsRetryable(err) {
+		t.Fatalf("expected non-retryable ErrJobExpired, got %v", err)
+	}
+	if ran {
+		t.Fatal("expired job should not run")
+	}
+}
@@ -0,0 +1,88 @@
+package lib
+
+import (
+	"encoding/hex"
+	"time"
+
+	"github.com/garyburd/redigo/redis"
+	"github.com/vmihailenco/msgpack/v5"
+)
+
+// PoisonMessage is a payload that could not be decoded as a job, kept on
+// its queue's poison list apart from ordinary dead-lettered failures.
+// QuarantinedAt is Unix seconds. DecodeError is set by PoisonMessages for
+// a poison list entry that is itself unreadable; Data then holds the raw
+// entry.
+type PoisonMessage struct {
+	Queue         string  `msgpack:"queue" json:"queue"`
+	Error         string  `msgpack:"error" json:"error"`
+	QuarantinedAt float64 `msgpack:"quarantined_at" json:"quarantined_at"`
+	Data          []byte  `msgpack:"data" json:"data"`
+	DecodeError   string  `msgpack:"-" json:"decode_error,omitempty"`
+}
+
+func newPoisonMessage(queue string, data []byte, cause error) *PoisonMessage {
+	return &PoisonMessage{Queue: queue, Error: cause.Error(), QuarantinedAt: float64(time.Now().Unix()), Data: data}
+}
+
+func (m *PoisonMessage) encode() ([]byte, error) {
+	return msgpack.Marshal(m)
+}
+
+// HexDump returns the raw payload in `hexdump -C` format.
+func (m *PoisonMessage) HexDump() string {
+	return hex.Dump(m.Data)
+}
+
+// quarantine moves undecodable data from the list src to queue's poison
+// list, together with the decode error cause, and increments the named
+// counters.
+func (g *Gores) quarantine(conn redis.Conn, src, queue string, data []byte, cause error, stats ...string) error {
+	out, err := newPoisonMessage(queue, data, cause).encode()
+	if err != nil {
+		return err
+	}
+	_, err = moveScript.Do(conn, counted([]interface{}{src, g.queueKey(queue, QUEUE_POISON), ""},
+		g.statKeys(queue, "", stats...), data, out, "")...)
+	return err
+}
+
+// PoisonMessages returns up to limit entries from queue's poison list,
+// newest first, skipping the first offset. Malformed entries are returned
+// with their DecodeError set rather than failing the call.
+func (g *Gores) PoisonMessages(queue string, offset, limit int) ([]*PoisonMessage, error) {
+	if limit <= 0 {
+		return nil, nil
+	}
+	conn := g.pool.Get()
+	defer conn.Close()
+
+	items, err := redis.ByteSlices(conn.Do("LRANGE", g.queueKey(queue, QUEUE_POISON), offset, offset+limit-1))
+	if err != nil {
+		return nil, err
+	}
+	msgs := make([]*PoisonMessage, 0, len(items))
+	for _, data := range items {
+		m := &PoisonMessage{}
+		if err := msgpack.Unmarshal(data, m); err != nil {
+			m = &PoisonMessage{Queue: queue, Data: data, DecodeError: err.Error()}
+		}
+		msgs = append(msgs, m)
+	}
+	return msgs, nil
+}
+
+// PurgePoison empties queue's poison list and returns how many entries it held.
+func (g *Gores) PurgePoison(queue string) (int, error) {
+	conn := g.pool.Get()
+	defer conn.Close()
+
+	conn.Send("MULTI")
+	conn.Send("LLEN", g.queueKey(queue, QUEUE_POISON))
+	conn.Send("DEL", g.queueKey(queue, QUEUE_POISON))
+	results, err := redis.Values(conn.Do("EXEC"))
+	if err != nil {
+		return 0, err
+	}
+	return redis.Int(results[0], nil)
+}
@@ -0,0 +1,67 @@
+package lib
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+
+	"github.com/garyburd/redigo/redis"
+)
+
+func TestUndecodableJobIsQuarantined(t *testing.T) {
+	cfg := newTestConfig()
+	g := NewGores(cfg)
+	defer g.Close()
+
+	const queue = "poison_queue"
+	conn := g.pool.Get()
+	defer conn.Close()
+	_, _ = conn.Do("DEL", g.queueKey(queue, QUEUE_PENDING), g.queueKey(queue, QUEUE_POISON),
+		g.queueKey(queue, QUEUE_DEADLETTER), g.processingKey(queue, testWorker))
+
+	future, _ := (&Job{Version: JOB_VERSION + 1, ID: "v2", Name: "Report", Queue: queue}).ToBytes()
+	for _, data := range [][]byte{[]byte("not msgpack"), future} {
+		conn.Do("LPUSH", g.queueKey(queue, QUEUE_PENDING), data)
+		_, fetched, _ := g.fetch(conn, testWorker, []string{queue})
+		g.handle(context.Background(), conn, WorkerConfig{}, testWorker, queue, fetched, NewServeMux())
+	}
+
+	if n, _ := redis.Int(conn.Do("LLEN", g.queueKey(queue, QUEUE_DEADLETTER))); n != 0 {
+		t.Fatalf("expected nothing dead-lettered, got %d", n)
+	}
+	msgs, err := g.PoisonMessages(queue, 0, 10)
+	if err != nil || len(msgs) != 2 {
+		t.Fatalf("expected 2 poison messages, got %d (%v)", len(msgs), err)
+	}
+	if !strings.Contains(msgs[0].Error, ErrUnknownVersion.Error()) || msgs[0].Queue != queue || msgs[0].QuarantinedAt == 0 {
+		t.Fatalf("expected unknown-version poison message, got %+v", msgs[0])
+	}
+	if !strings.Contains(msgs[1].HexDump(), "|not msgpack|") {
+		t.Fatalf("unexpected hex dump:\n%s", msgs[1].HexDump())
+	}
+
+	conn.Do("LPUSH", g.queueKey(queue, QUEUE_POISON), "garbage")
+	msgs, err = g.PoisonMessages(queue, 0, 10)
+	if err != nil || len(msgs) != 3 {
+		t.Fatalf("expected a malformed entry not to fail the listing, got %d (%v)", len(msgs), err)
+	}
+	if msgs[0].DecodeError == "" || string(msgs[0].Data) != "garbage" || msgs[1].DecodeError != "" {
+		t.Fatalf("expected the raw malformed entry with its decode error, got %+v", msgs[0])
+	}
+
+	if n, err := g.PurgePoison(queue); err != nil || n != 3 {
+		t.Fatalf("expected to purge 3 poison messages, got %d (%v)", n, err)
+	}
+}
+
+func TestFromBytesRejectsNewerVersion(t *testing.T) {
+	data, _ := (&Job{Version: JOB_VERSION + 1, ID: "v2"}).ToBytes()
+	if _, err := FromBytes(data); !errors.Is(err, ErrUnknownVersion) {
+		t.Fatalf("expected ErrUnknownVersion, got %v", err)
+	}
+	data, _ = (&Job{ID: "v0"}).ToBytes()
+	if job, err := FromBytes(data); err != nil || job.ID != "v0" {
+		t.Fatalf("expected unversioned job to decode, got %v", err)
+	}
+}
@@ -0,0 +1,69 @@
+package lib
+
+import (
+	"math/rand/v2"
+	"time"
+)
+
+const (
+	PRIORITY_STRICT   = "strict"
+	PRIORITY_WEIGHTED = "weighted"
+)
+
+// queueSelector decides the order a worker polls its queues in. In strict
+// mode queues are always polled in the configured order, so a later queue is
+// only served once every earlier one is empty. In weighted mode the first
+// queue is drawn proportionally to its weight, which keeps low-weight queues
+// from starving. A selector is not safe for concurrent use.
+type queueSelector struct {
+	queues  []string
+	weights []int
+	strict  bool
+	rnd     *rand.Rand
+}
+
+func newQueueSelector(cfg WorkerConfig, queues []string, seed uint64) *queueSelector {
+	s := &queueSelector{
+		queues: queues,
+		strict: cfg.Priority == PRIORITY_STRICT,
+		rnd:    rand.New(rand.NewPCG(seed, uint64(time.Now().UnixNano()))),
+	}
+	s.weights = make([]int, len(queues))
+	for i, q := range queues {
+		s.weights[i] = 1
+		if w, ok := cfg.Weights[q]; ok && w > 0 {
+			s.weights[i] = w
+		}
+	}
+	return s
+}
+
+// order returns the queues in the sequence they should be polled for the next fetch.
+func (s *queueSelector) order() []string {
+	if s.strict || len(s.queues) == 1 {
+		return s.queues
+	}
+	total := 0
+	remaining := make([]int, len(s.weights))
+	for i, w := range s.weights {
+		remaining[i] = w
+		total += w
+	}
+	order := make([]string, 0, len(s.queues))
+	for len(order) < len(s.queues) {
+		r := s.rnd.IntN(total)
+		for i, w := range remaining {
+			if w == 0 {
+				continue
+			}
+			if r < w {
+				order = append(order, s.queues[i])
+				total -= w
+				remaining[i] = 0
+				break
+			}
+			r -= w
+		}
+	}
+	return order
+}
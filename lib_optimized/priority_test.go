@@ -0,0 +1,50 @@
+package lib
+
+import (
+	"math"
+	"testing"
+)
+
+func TestQueueSelectorStrict(t *testing.T) {
+	cfg := WorkerConfig{Priority: PRIORITY_STRICT, Weights: map[string]int{"default": 10}}
+	s := newQueueSelector(cfg, []string{"critical", "default"}, 1)
+	for i := 0; i < 100; i++ {
+		if order := s.order(); order[0] != "critical" || order[1] != "default" {
+			t.Fatalf("strict order changed: %v", order)
+		}
+	}
+}
+
+func TestQueueSelectorWeighted(t *testing.T) {
+	queues := []string{"critical", "default", "low"}
+	cfg := WorkerConfig{
+		Priority: PRIORITY_WEIGHTED,
+		Weights:  map[string]int{"critical": 6, "default": 3, "low": 1},
+	}
+	s := newQueueSelector(cfg, queues, 42)
+
+	const draws = 20000
+	first := map[string]int{}
+	for i := 0; i < draws; i++ {
+		order := s.order()
+		if len(order) != len(queues) {
+			t.Fatalf("order dropped queues: %v", order)
+		}
+		first[order[0]]++
+	}
+
+	want := map[string]float64{"critical": 0.6, "default": 0.3, "low": 0.1}
+	for q, share := range want {
+		got := float64(first[q]) / draws
+		if math.Abs(got-share) > 0.03 {
+			t.Errorf("queue %s picked first %.3f of the time, want ~%.1f", q, got, share)
+		}
+	}
+}
+
+func TestQueueSelectorDefaultWeights(t *testing.T) {
+	s := newQueueSelector(WorkerConfig{}, []string{"a", "b"}, 7)
+	if s.weights[0] != 1 || s.weights[1] != 1 {
+		t.Fatalf("expected equal default weights, got %v", s.weights)
+	}
+}
@@ -0,0 +1,42 @@
+package lib
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"github.com/garyburd/redigo/redis"
+)
+
+// luaProgress sets the progress ARGV[1] and message ARGV[2] of the status
+// record KEYS[1] while its job is active, so a handler that outlives its
+// attempt cannot recreate an expired record.
+const luaProgress = `
+	if redis.call('HGET', KEYS[1], 'state') == 'active' then
+		redis.call('HSET', KEYS[1], 'progress', ARGV[1], 'message', ARGV[2])
+	end
+`
+
+var progressScript = redis.NewScript(1, luaProgress)
+
+// ReportProgress records how far the job running under ctx has got, as a
+// percentage from 0 to 100 and a free-form message. Both are written to the
+// job's status record straight away and read back through GetJobStatus.
+func ReportProgress(ctx context.Context, percent int, msg string) error {
+	r, ok := ctx.Value(jobRunKey{}).(*jobRun)
+	if !ok {
+		return errors.New("gores: ReportProgress called outside a job")
+	}
+	if percent < 0 || percent > 100 {
+		return fmt.Errorf("gores: progress %d out of range", percent)
+	}
+
+	conn, err := r.g.pool.GetContext(ctx)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+
+	_, err = progressScript.Do(conn, r.g.statusKey(r.id), percent, msg)
+	return err
+}
@@ -0,0 +1,142 @@
+package lib
+
+import (
+	"context"
+	"log"
+	"strings"
+	"time"
+
+	"github.com/garyburd/redigo/redis"
+)
+
+const (
+	REAPER_INTERVAL        = 30 * time.Second
+	DEFAULT_MAX_RECOVERIES = 3
+)
+
+// runReaper periodically recovers jobs held by dead workers until ctx is done.
+func (g *Gores) runReaper(ctx context.Context, maxRecoveries int) {
+	ticker := time.NewTicker(REAPER_INTERVAL)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			conn := g.pool.Get()
+			if _, err := g.reap(conn, maxRecoveries); err != nil {
+				log.Printf("Reaper failed: %v", err)
+			}
+			conn.Close()
+		}
+	}
+}
+
+// reap finds registered workers whose heartbeat has expired and pushes the
+// jobs left on their processing lists back to pending, or to the dead-letter
+// list once a job has been recovered more than maxRecoveries times. It
+// returns the number of jobs moved. Concurrent reapers are safe: each job is
+// moved by whichever reaper removes it from the processing list first.
+func (g *Gores) reap(conn redis.Conn, maxRecoveries int) (int, error) {
+	ids, err := redis.Strings(conn.Do("SMEMBERS", g.prefix+WORKERS))
+	if err != nil {
+		return 0, err
+	}
+
+	moved := 0
+	for _, id := range ids {
+		alive, err := redis.Bool(conn.Do("EXISTS", g.prefix+HEARTBEAT+id))
+		if err != nil {
+			return moved, err
+		}
+		if alive {
+			continue
+		}
+		queues, err := redis.String(conn.Do("HGET", g.prefix+WORKER+id, "queues"))
+		if err != nil && err != redis.ErrNil {
+			return moved, err
+		}
+		for _, q := range strings.Split(queues, ",") {
+			if q == "" {
+				continue
+			}
+			n, err := g.recoverList(conn, q, g.processingKey(q, id), maxRecoveries)
+			moved += n
+			if err != nil {
+				return moved, err
+			}
+		}
+		log.Printf("Reaper: recovered jobs from dead worker %s", id)
+		if err := g.deregister(conn, id); err != nil {
+			return moved, err
+		}
+	}
+	return moved, nil
+}
+
+// recoverList drains an orphaned processing list belonging to queue. Jobs
+// CancelJob asked to stop are dropped and marked cancelled instead.
+func (g *Gores) recoverList(conn redis.Conn, queue, processing string, maxRecoveries int) (int, error) {
+	items, err := redis.ByteSlices(conn.Do("LRANGE", processing, 0, -1))
+	if err != nil {
+		return 0, err
+	}
+
+	moved := 0
+	for _, data := range items {
+		dest, out, lock, id := g.queueKey(queue, QUEUE_POISON), data, "", ""
+		var counters statUpdate
+		if job, err := FromBytes(data); err != nil {
+			if out, err = newPoisonMessage(queue, data, err).encode(); err != nil {
+				return moved, err
+			}
+		} else {
+			if dropped, err := g.dropCancelled(conn, processing, data, job); err != nil || dropped {
+				PutJob(job)
+				if err != nil {
+					return moved, err
+				}
+				continue
+			}
+			job.Recoveries++
+			job.rejoin(time.Now())
+			lost := job.Recoveries > maxRecoveries
+			if lost {
+				job.markFailed("worker lost", job.RetryCount+job.Recoveries, time.Now())
+			}
+			out, err = job.ToBytes()
+			if err != nil {
+				PutJob(job)
+				return moved, err
+			}
+			if lost {
+				dest, lock, id = g.queueKey(queue, QUEUE_DEADLETTER), g.uniqueLockKey(job), job.ID
+				counters = g.statKeys(queue, job.Name, STAT_PROCESSED, STAT_FAILED, STAT_DEAD)
+				g.finishStatus(conn, job.ID, "state", STATUS_DEAD, "error", "worker lost", "finished_at", time.Now().Unix())
+			} else {
+				dest = g.priorityKey(queue, QUEUE_PENDING, job.priority())
+				g.sendStatus(conn, job.ID, "state", STATUS_QUEUED, "payload", out)
+			}
+			PutJob(job)
+		}
+		n, err := redis.Int(moveScript.Do(conn, counted([]interface{}{processing, dest, lock}, counters, data, out, id)...))
+		if err != nil {
+			return moved, err
+		}
+		moved += n
+	}
+	return moved, nil
+}
+
+// dropCancelled removes job, encoded as data, from the processing list and
+// marks it cancelled if CancelJob asked to stop it, reporting whether it did.
+func (g *Gores) dropCancelled(conn redis.Conn, processing string, data []byte, job *Job) (bool, error) {
+	requested, err := g.cancelRequested(conn, job.ID)
+	if err != nil || !requested {
+		return false, err
+	}
+	g.finishStatus(conn, job.ID, "state", STATUS_CANCELLED, "finished_at", time.Now().Unix())
+	_, err = ackScript.Do(conn, counted([]interface{}{processing, g.uniqueLockKey(job)}, statUpdate{}, data, job.ID)...)
+	return true, err
+}
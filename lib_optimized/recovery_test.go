@@ -0,0 +1,55 @@
+package lib
+
+import (
+	"testing"
+
+	"github.com/garyburd/redigo/redis"
+)
+
+func TestReapRecoversDeadWorkerJobs(t *testing.T) {
+	cfg := newTestConfig()
+	g := NewGores(cfg)
+	defer g.Close()
+
+	const queue = "reap_queue"
+	const dead = "dead-host:1"
+	conn := g.pool.Get()
+	defer conn.Close()
+	_, _ = conn.Do("DEL", g.prefix+WORKERS, g.queueKey(queue, QUEUE_PENDING), g.queueKey(queue, QUEUE_DEADLETTER), g.processingKey(queue, dead))
+
+	if err := g.register(conn, dead, 0, []string{queue}); err != nil {
+		t.Fatalf("register: %v", err)
+	}
+	fresh, _ := (&Job{ID: "fresh", Name: "PrintJob", Queue: queue}).ToBytes()
+	worn, _ := (&Job{ID: "worn", Name: "PrintJob", Queue: queue, Recoveries: 2}).ToBytes()
+	_, _ = conn.Do("LPUSH", g.processingKey(queue, dead), fresh, worn)
+
+	// Live worker: nothing is touched.
+	if n, err := g.reap(conn, 2); err != nil || n != 0 {
+		t.Fatalf("reaped a live worker: n=%d err=%v", n, err)
+	}
+
+	_, _ = conn.Do("DEL", g.prefix+HEARTBEAT+dead)
+	n, err := g.reap(conn, 2)
+	if err != nil || n != 2 {
+		t.Fatalf("expected 2 recovered jobs, n=%d err=%v", n, err)
+	}
+
+	pending, _ := redis.ByteSlices(conn.Do("LRANGE", g.queueKey(queue, QUEUE_PENDING), 0, -1))
+	if len(pending) != 1 {
+		t.Fatalf("expected 1 requeued job, got %d", len(pending))
+	}
+	job, err := FromBytes(pending[0])
+	if err != nil {
+		t.Fatalf("unmarshal: %v", err)
+	}
+	if job.ID != "fresh" || job.Recoveries != 1 {
+		t.Errorf("unexpected requeued job %+v", job)
+	}
+	if l, _ := redis.Int(conn.Do("LLEN", g.queueKey(queue, QUEUE_DEADLETTER))); l != 1 {
+		t.Errorf("expected over-recovered job on dead-letter list, got %d", l)
+	}
+	if member, _ := redis.Bool(conn.Do("SISMEMBER", g.prefix+WORKERS, dead)); member {
+		t.Errorf("expected dead worker to be deregistered")
+	}
+}
@@ -0,0 +1,172 @@
+package lib
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/garyburd/redigo/redis"
+)
+
+const (
+	WORKERS   = "workers"
+	WORKER    = "worker:"
+	HEARTBEAT = "heartbeat:"
+
+	HEARTBEAT_INTERVAL = 10 * time.Second
+	HEARTBEAT_TTL      = 3 * HEARTBEAT_INTERVAL
+)
+
+// WorkerInfo describes a registered worker and the jobs on its processing lists.
+type WorkerInfo struct {
+	ID        string    `json:"id"`
+	Host      string    `json:"host"`
+	PID       int       `json:"pid"`
+	Index     int       `json:"index"`
+	Queues    []string  `json:"queues"`
+	StartedAt time.Time `json:"started_at"`
+	Heartbeat time.Time `json:"heartbeat"`
+	Alive     bool      `json:"alive"`
+	Jobs      []string  `json:"jobs"`
+}
+
+// workerIdentity returns a unique "host:pid:run:index" identity for a
+// worker goroutine of the pool started as run. Restarted containers often
+// reuse both hostname and PID, so run tells their workers apart from those
+// of the previous run, whose jobs are then left for a reaper to recover.
+func workerIdentity(run string, index int) string {
+	host, err := os.Hostname()
+	if err != nil {
+		host = "unknown"
+	}
+	return fmt.Sprintf("%s:%d:%s:%d", host, os.Getpid(), run, index)
+}
+
+// processingKey returns the processing list a given worker uses for queue,
+// so that each worker's in-flight jobs can be told apart.
+func (g *Gores) processingKey(queue, workerID string) string {
+	return g.queueKey(queue, QUEUE_PROCESS) + ":" + workerID
+}
+
+// register records a worker, the queues it consumes and its start time, and
+// sends its first heartbeat.
+func (g *Gores) register(conn redis.Conn, workerID string, index int, queues []string) error {
+	now := time.Now()
+	conn.Send("MULTI")
+	g.sendRegistration(conn, workerID, index, queues, now, now)
+	_, err := conn.Do("EXEC")
+	return err
+}
+
+// sendRegistration queues the full registry entry and heartbeat of a worker
+// on conn. Every heartbeat sends it, so a worker wrongly reaped during a
+// stall registers itself again.
+func (g *Gores) sendRegistration(conn redis.Conn, workerID string, index int, queues []string, started, now time.Time) {
+	host, _ := os.Hostname()
+	conn.Send("SADD", g.prefix+WORKERS, workerID)
+	conn.Send("HSET", g.prefix+WORKER+workerID,
+		"host", host,
+		"pid", os.Getpid(),
+		"index", index,
+		"queues", strings.Join(queues, ","),
+		"started_at", started.Unix(),
+		"heartbeat", now.Unix(),
+	)
+	conn.Send("SET", g.prefix+HEARTBEAT+workerID, now.Unix(), "EX", int(HEARTBEAT_TTL/time.Second))
+}
+
+// deregister removes a worker from the registry.
+func (g *Gores) deregister(conn redis.Conn, workerID string) error {
+	conn.Send("MULTI")
+	conn.Send("SREM", g.prefix+WORKERS, workerID)
+	conn.Send("DEL", g.prefix+WORKER+workerID, g.prefix+HEARTBEAT+workerID)
+	_, err := conn.Do("EXEC")
+	return err
+}
+
+// heartbeat re-registers every worker in the pool, indexed by position in
+// workerIDs, until ctx is done.
+func (g *Gores) heartbeat(ctx context.Context, workerIDs, queues []string, started time.Time) {
+	ticker := time.NewTicker(HEARTBEAT_INTERVAL)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case now := <-ticker.C:
+			conn := g.pool.Get()
+			err := g.beat(conn, workerIDs, queues, started, now)
+			conn.Close()
+			if err != nil {
+				log.Printf("Heartbeat failed: %v", err)
+			}
+		}
+	}
+}
+
+// beat atomically refreshes the registration of every worker in workerIDs.
+func (g *Gores) beat(conn redis.Conn, workerIDs, queues []string, started, now time.Time) error {
+	conn.Send("MULTI")
+	for i, id := range workerIDs {
+		g.sendRegistration(conn, id, i, queues, started, now)
+	}
+	_, err := conn.Do("EXEC")
+	return err
+}
+
+// Workers lists every registered worker along with the IDs of the jobs it
+// currently holds. Workers whose heartbeat expired are reported with
+// Alive false until a reaper recovers their jobs.
+func (g *Gores) Workers() ([]WorkerInfo, error) {
+	conn := g.pool.Get()
+	defer conn.Close()
+
+	ids, err := redis.Strings(conn.Do("SMEMBERS", g.prefix+WORKERS))
+	if err != nil {
+		return nil, err
+	}
+
+	workers := make([]WorkerInfo, 0, len(ids))
+	for _, id := range ids {
+		fields, err := redis.StringMap(conn.Do("HGETALL", g.prefix+WORKER+id))
+		if err != nil {
+			return nil, err
+		}
+		alive, err := redis.Bool(conn.Do("EXISTS", g.prefix+HEARTBEAT+id))
+		if err != nil {
+			return nil, err
+		}
+
+		w := WorkerInfo{ID: id, Host: fields["host"], Alive: alive, Jobs: []string{}}
+		w.PID, _ = strconv.Atoi(fields["pid"])
+		w.Index, _ = strconv.Atoi(fields["index"])
+		if started, err := strconv.ParseInt(fields["started_at"], 10, 64); err == nil {
+			w.StartedAt = time.Unix(started, 0)
+		}
+		if beat, err := strconv.ParseInt(fields["heartbeat"], 10, 64); err == nil {
+			w.Heartbeat = time.Unix(beat, 0)
+		}
+		if fields["queues"] != "" {
+			w.Queues = strings.Split(fields["queues"], ",")
+		}
+		for _, q := range w.Queues {
+			items, err := redis.ByteSlices(conn.Do("LRANGE", g.processingKey(q, id), 0, -1))
+			if err != nil {
+				return nil, err
+			}
+			for _, data := range items {
+				if job, err := FromBytes(data); err == nil {
+					w.Jobs = append(w.Jobs, job.ID)
+					PutJob(job)
+				}
+			}
+		}
+		workers = append(workers, w)
+	}
+	return workers, nil
+}
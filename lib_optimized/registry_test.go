@@ -0,0 +1,109 @@
+package lib
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestWorkerIdentity(t *testing.T) {
+	a, b := workerIdentity("run1", 0), workerIdentity("run1", 1)
+	if a == b {
+		t.Fatalf("expected distinct identities, got %s twice", a)
+	}
+	if !strings.HasSuffix(b, ":run1:1") || strings.Count(b, ":") < 3 {
+		t.Fatalf("expected host:pid:run:index identity, got %s", b)
+	}
+	// A restarted process with the same hostname and PID must not take
+	// over the previous run's identities.
+	if c := workerIdentity("run2", 0); c == a {
+		t.Fatalf("expected identities to differ between runs, got %s twice", c)
+	}
+}
+
+func TestWorkersListsRegisteredWorkers(t *testing.T) {
+	cfg := newTestConfig()
+	g := NewGores(cfg)
+	defer g.Close()
+
+	const queue = "registry_queue"
+	id := workerIdentity("run", 3)
+	conn := g.pool.Get()
+	defer conn.Close()
+	_, _ = conn.Do("DEL", g.prefix+WORKERS, g.processingKey(queue, id))
+
+	if err := g.register(conn, id, 3, []string{queue}); err != nil {
+		t.Fatalf("register: %v", err)
+	}
+	data, _ := (&Job{ID: "in-flight", Name: "PrintJob", Queue: queue}).ToBytes()
+	_, _ = conn.Do("LPUSH", g.processingKey(queue, id), data)
+
+	workers, err := g.Workers()
+	if err != nil {
+		t.Fatalf("workers: %v", err)
+	}
+	if len(workers) != 1 {
+		t.Fatalf("expected 1 worker, got %d", len(workers))
+	}
+	w := workers[0]
+	if w.ID != id || w.Index != 3 || !w.Alive || w.StartedAt.IsZero() || w.Heartbeat.IsZero() {
+		t.Errorf("unexpected worker info %+v", w)
+	}
+	if len(w.Jobs) != 1 || w.Jobs[0] != "in-flight" {
+		t.Errorf("expected in-flight job to be reported, got %v", w.Jobs)
+	}
+
+	if err := g.deregister(conn, id); err != nil {
+		t.Fatalf("deregister: %v", err)
+	}
+	if workers, _ := g.Workers(); len(workers) != 0 {
+		t.Errorf("expected registry to be empty, got %+v", workers)
+	}
+}
+
+func TestHeartbeatReregistersReapedWorker(t *testing.T) {
+	cfg := newTestConfig()
+	g := NewGores(cfg)
+	defer g.Close()
+
+	const queue = "registry_queue"
+	ids := []string{workerIdentity("run", 0), workerIdentity("run", 1)}
+	conn := g.pool.Get()
+	defer conn.Close()
+	_, _ = conn.Do("DEL", g.prefix+WORKERS)
+
+	started := time.Now().Add(-time.Minute)
+	if err := g.register(conn, ids[1], 1, []string{queue}); err != nil {
+		t.Fatalf("register: %v", err)
+	}
+	// A stall long enough for a reaper to give the worker up.
+	if err := g.deregister(conn, ids[1]); err != nil {
+		t.Fatalf("deregister: %v", err)
+	}
+	if err := g.beat(conn, ids, []string{queue}, started, time.Now()); err != nil {
+		t.Fatalf("beat: %v", err)
+	}
+
+	workers, err := g.Workers()
+	if err != nil {
+		t.Fatalf("workers: %v", err)
+	}
+	if len(workers) != 2 {
+		t.Fatalf("expected both workers to be registered again, got %+v", workers)
+	}
+	for _, w := range workers {
+		if !w.Alive || w.Index != indexOf(ids, w.ID) || len(w.Queues) != 1 || w.StartedAt.Unix() != started.Unix() {
+			t.Errorf("unexpected worker info %+v", w)
+		}
+		_ = g.deregister(conn, w.ID)
+	}
+}
+
+func indexOf(ids []string, id string) int {
+	for i, v := range ids {
+		if v == id {
+			return i
+		}
+	}
+	return -1
+}
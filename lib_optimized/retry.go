@@ -0,0 +1,166 @@
+package lib
+
+import (
+	"math/rand/v2"
+	"time"
+
+	"github.com/garyburd/redigo/redis"
+)
+
+const (
+	DEFAULT_MAX_RETRIES = 3
+	DEFAULT_MAX_BACKOFF = time.Hour
+	BACKOFF_BASE        = time.Second
+
+	BACKOFF_CONSTANT    = "constant"
+	BACKOFF_LINEAR      = "linear"
+	BACKOFF_EXPONENTIAL = "exponential"
+)
+
+// The settling scripts below take a variable number of keys: after their
+// fixed keys come counters, built by counted, that luaIncrStats increments
+// in the same step as the job is removed from its source list.
+
+// luaRetry acknowledges ARGV[1] on the processing list KEYS[1] and schedules
+// its updated copy ARGV[2] on the retry set KEYS[2] at score ARGV[3]. Nothing
+// is scheduled if the job is no longer on the processing list.
+const luaRetry = `
+	if redis.call('LREM', KEYS[1], 1, ARGV[1]) == 0 then
+		return 0
+	end
+	redis.call('ZADD', KEYS[2], ARGV[3], ARGV[2])
+	local first = 3
+` + luaIncrStats + `
+	return 1
+`
+
+// luaMove removes ARGV[1] from the list KEYS[1] and, if it was still there,
+// pushes ARGV[2] onto the list KEYS[2]. If KEYS[3] is set, the uniqueness
+// lock it names is released when it is still held by job ID ARGV[3].
+const luaMove = `
+	if redis.call('LREM', KEYS[1], 1, ARGV[1]) == 0 then
+		return 0
+	end
+	redis.call('LPUSH', KEYS[2], ARGV[2])
+	if KEYS[3] ~= '' and redis.call('GET', KEYS[3]) == ARGV[3] then
+		redis.call('DEL', KEYS[3])
+	end
+	local first = 4
+` + luaIncrStats + `
+	return 1
+`
+
+// luaAck removes ARGV[1] from the processing list KEYS[1] and releases the
+// uniqueness lock KEYS[2], if set, when it is still held by job ID ARGV[2].
+const luaAck = `
+	local removed = redis.call('LREM', KEYS[1], 1, ARGV[1])
+	if KEYS[2] ~= '' and redis.call('GET', KEYS[2]) == ARGV[2] then
+		redis.call('DEL', KEYS[2])
+	end
+	if removed > 0 then
+		local first = 3
+` + luaIncrStats + `
+	end
+	return removed
+`
+
+var (
+	retryScript = redis.NewScript(-1, luaRetry)
+	moveScript  = redis.NewScript(-1, luaMove)
+	ackScript   = redis.NewScript(-1, luaAck)
+)
+
+// maxRetries returns how many times the job may be retried after its first
+// attempt. Jobs enqueued with Retry false are never retried.
+func (j *Job) maxRetries() int {
+	if !j.Retry {
+		return 0
+	}
+	if j.MaxRetries > 0 {
+		return j.MaxRetries
+	}
+	return DEFAULT_MAX_RETRIES
+}
+
+// retryBackoff returns how long to wait before the given retry attempt
+// (1-based) under the job's backoff strategy, capped at its max backoff.
+// Exponential backoff adds up to 50% random jitter.
+func (j *Job) retryBackoff(retry int) time.Duration {
+	limit := j.MaxBackoff
+	if limit == 0 {
+		limit = DEFAULT_MAX_BACKOFF
+	}
+
+	var d time.Duration
+	switch j.Backoff {
+	case BACKOFF_CONSTANT:
+		d = BACKOFF_BASE
+	case BACKOFF_LINEAR:
+		d = time.Duration(retry) * BACKOFF_BASE
+	default:
+		if retry > 32 {
+			return limit
+		}
+		d = time.Duration(1<<uint(retry-1)) * BACKOFF_BASE
+		d += time.Duration(rand.Int64N(int64(d)/2 + 1))
+	}
+	if d > limit {
+		return limit
+	}
+	return d
+}
+
+// retryOrBury removes a job that failed with cause from the worker's
+// processing list and either schedules it on the retry set with an
+// incremented RetryCount or, once its retry policy is exhausted, cause is
+// not retryable or the retry would run past its deadline, moves it to the
+// dead-letter list. The job's status record is updated to match. Jobs that
+// cannot be decoded are quarantined on the poison list instead.
+func (g *Gores) retryOrBury(conn redis.Conn, workerID, queue string, data []byte, cause error) error {
+	processing := g.processingKey(queue, workerID)
+	job, err := FromBytes(data)
+	if err != nil {
+		return g.quarantine(conn, processing, queue, data, err, STAT_PROCESSED, STAT_FAILED)
+	}
+	defer PutJob(job)
+
+	now := time.Now()
+	if !IsRetryable(cause) {
+		return g.bury(conn, queue, processing, data, job, STATUS_FAILED, cause, now)
+	}
+	if job.RetryCount >= job.maxRetries() {
+		return g.bury(conn, queue, processing, data, job, STATUS_DEAD, cause, now)
+	}
+
+	runAt := now.Add(job.retryBackoff(job.RetryCount + 1))
+	if job.expired(runAt) {
+		return g.bury(conn, queue, processing, data, job, STATUS_FAILED, cause, now)
+	}
+	job.RetryCount++
+	job.rejoin(runAt)
+	retryData, err := job.ToBytes()
+	if err != nil {
+		return err
+	}
+	g.sendStatus(conn, job.ID, "state", STATUS_RETRYING, "error", cause.Error(),
+		"retry_count", job.RetryCount, "run_at", runAt.Unix(), "payload", retryData)
+	_, err = retryScript.Do(conn, counted([]interface{}{processing, g.priorityKey(queue, QUEUE_RETRY, job.priority())},
+		g.statKeys(queue, job.Name, STAT_PROCESSED, STAT_FAILED, STAT_RETRIED),
+		data, retryData, runAt.Unix())...)
+	return err
+}
+
+// bury moves job from processing to queue's dead-letter list, recording
+// state and cause in its status record and the failure on the dead copy.
+func (g *Gores) bury(conn redis.Conn, queue, processing string, data []byte, job *Job, state string, cause error, now time.Time) error {
+	job.markFailed(cause.Error(), job.RetryCount+1, now)
+	out, err := job.ToBytes()
+	if err != nil {
+		return err
+	}
+	g.finishStatus(conn, job.ID, "state", state, "error", cause.Error(), "finished_at", now.Unix())
+	_, err = moveScript.Do(conn, counted([]interface{}{processing, g.queueKey(queue, QUEUE_DEADLETTER), g.uniqueLockKey(job)},
+		g.statKeys(queue, job.Name, STAT_PROCESSED, STAT_FAILED, STAT_DEAD),
+		data, out, job.ID)...)
+	return err
+}
@@ -0,0 +1,51 @@
+package lib
+
+import (
+	"testing"
+	"time"
+)
+
+func TestRetryBackoffStrategies(t *testing.T) {
+	constant := &Job{Backoff: BACKOFF_CONSTANT}
+	if d := constant.retryBackoff(5); d != BACKOFF_BASE {
+		t.Errorf("constant backoff: got %v", d)
+	}
+
+	linear := &Job{Backoff: BACKOFF_LINEAR}
+	if d := linear.retryBackoff(3); d != 3*BACKOFF_BASE {
+		t.Errorf("linear backoff: got %v", d)
+	}
+
+	exp := &Job{}
+	for retry := 1; retry <= 4; retry++ {
+		base := time.Duration(1<<uint(retry-1)) * BACKOFF_BASE
+		d := exp.retryBackoff(retry)
+		if d < base || d > base+base/2 {
+			t.Errorf("exponential backoff for retry %d: got %v, want [%v, %v]", retry, d, base, base+base/2)
+		}
+	}
+
+	capped := &Job{Backoff: BACKOFF_LINEAR, MaxBackoff: 5 * time.Second}
+	if d := capped.retryBackoff(100); d != 5*time.Second {
+		t.Errorf("expected backoff capped at 5s, got %v", d)
+	}
+	if d := exp.retryBackoff(64); d != DEFAULT_MAX_BACKOFF {
+		t.Errorf("expected default cap for huge retry, got %v", d)
+	}
+}
+
+func TestMaxRetries(t *testing.T) {
+	tests := []struct {
+		job  *Job
+		want int
+	}{
+		{&Job{Retry: false, MaxRetries: 10}, 0},
+		{&Job{Retry: true}, DEFAULT_MAX_RETRIES},
+		{&Job{Retry: true, MaxRetries: 7}, 7},
+	}
+	for _, tt := range tests {
+		if got := tt.job.maxRetries(); got != tt.want {
+			t.Errorf("maxRetries(%+v) = %d, want %d", tt.job, got, tt.want)
+		}
+	}
+}
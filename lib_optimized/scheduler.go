@@ -0,0 +1,82 @@
+package lib
+
+import (
+	"context"
+	"log"
+	"time"
+
+	"github.com/garyburd/redigo/redis"
+)
+
+const (
+	SCHEDULER_INTERVAL = time.Second
+	SCHEDULER_BATCH    = 100
+)
+
+// luaForward moves up to ARGV[2] jobs whose score is <= ARGV[1] from each
+// sorted set KEYS[1], KEYS[3], ... onto the pending list following it. It
+// returns how many jobs it moved in all and from the fullest set. Running
+// it as a single script means concurrent forwarders can never promote the
+// same job twice.
+const luaForward = `
+	local total, fullest = 0, 0
+	for i = 1, #KEYS, 2 do
+		local due = redis.call('ZRANGEBYSCORE', KEYS[i], '-inf', ARGV[1], 'LIMIT', 0, ARGV[2])
+		for _, data in ipairs(due) do
+			redis.call('ZREM', KEYS[i], data)
+			redis.call('LPUSH', KEYS[i + 1], data)
+		end
+		total, fullest = total + #due, math.max(fullest, #due)
+	end
+	return {total, fullest}
+`
+
+var forwardScript = redis.NewScript(-1, luaForward)
+
+// runScheduler periodically promotes due delayed and retried jobs on every
+// queue until ctx is done.
+func (g *Gores) runScheduler(ctx context.Context, queues []string) {
+	ticker := time.NewTicker(SCHEDULER_INTERVAL)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case now := <-ticker.C:
+			conn := g.pool.Get()
+			for _, q := range queues {
+				if _, err := g.forward(conn, q, now); err != nil {
+					log.Printf("Scheduler: forwarding %s failed: %v", q, err)
+				}
+			}
+			conn.Close()
+		}
+	}
+}
+
+// forward promotes every job on the queue's delayed and retry sets that is
+// due at now onto the pending list of its priority and returns how many
+// were moved.
+func (g *Gores) forward(conn redis.Conn, queue string, now time.Time) (int, error) {
+	pending := g.priorityKeys(queue, QUEUE_PENDING)
+	args := []interface{}{len(pending) * 4}
+	for _, set := range []string{QUEUE_DELAYED, QUEUE_RETRY} {
+		for i, key := range g.priorityKeys(queue, set) {
+			args = append(args, key, pending[i])
+		}
+	}
+	args = append(args, now.Unix(), SCHEDULER_BATCH)
+
+	total := 0
+	for {
+		n, err := redis.Ints(forwardScript.Do(conn, args...))
+		if err != nil {
+			return total, err
+		}
+		total += n[0]
+		if n[1] < SCHEDULER_BATCH {
+			return total, nil
+		}
+	}
+}
@@ -0,0 +1,71 @@
+package lib
+
+import (
+	"testing"
+	"time"
+
+	"github.com/garyburd/redigo/redis"
+)
+
+func TestEnqueueInForwardsWhenDue(t *testing.T) {
+	cfg := newTestConfig()
+	g := NewGores(cfg)
+	defer g.Close()
+
+	conn := g.pool.Get()
+	defer conn.Close()
+	_, _ = conn.Do("DEL", g.queueKey("delayed_queue", QUEUE_PENDING), g.queueKey("delayed_queue", QUEUE_DELAYED))
+
+	job := map[string]interface{}{
+		"Name":  "PrintJob",
+		"Queue": "delayed_queue",
+		"Args":  map[string]interface{}{"id": float64(1)},
+		"Retry": true,
+	}
+	if _, err := g.EnqueueIn(job, time.Hour); err != nil {
+		t.Fatalf("enqueue in: %v", err)
+	}
+	if _, err := g.EnqueueAt(job, time.Now().Add(2*time.Hour)); err != nil {
+		t.Fatalf("enqueue at: %v", err)
+	}
+
+	if n, _ := redis.Int(conn.Do("ZCARD", g.queueKey("delayed_queue", QUEUE_DELAYED))); n != 2 {
+		t.Fatalf("expected 2 delayed jobs, got %d", n)
+	}
+
+	moved, err := g.forward(conn, "delayed_queue", time.Now())
+	if err != nil || moved != 0 {
+		t.Fatalf("forwarded early: moved=%d err=%v", moved, err)
+	}
+
+	moved, err = g.forward(conn, "delayed_queue", time.Now().Add(90*time.Minute))
+	if err != nil || moved != 1 {
+		t.Fatalf("expected 1 due job, moved=%d err=%v", moved, err)
+	}
+	if n, _ := redis.Int(conn.Do("LLEN", g.queueKey("delayed_queue", QUEUE_PENDING))); n != 1 {
+		t.Fatalf("expected 1 pending job, got %d", n)
+	}
+}
+
+func TestEnqueueAtPastIsImmediate(t *testing.T) {
+	cfg := newTestConfig()
+	g := NewGores(cfg)
+	defer g.Close()
+
+	conn := g.pool.Get()
+	defer conn.Close()
+	_, _ = conn.Do("DEL", g.queueKey("delayed_queue", QUEUE_PENDING), g.queueKey("delayed_queue", QUEUE_DELAYED))
+
+	job := map[string]interface{}{
+		"Name":  "PrintJob",
+		"Queue": "delayed_queue",
+		"Args":  map[string]interface{}{},
+		"Retry": false,
+	}
+	if _, err := g.EnqueueAt(job, time.Now().Add(-time.Minute)); err != nil {
+		t.Fatalf("enqueue at: %v", err)
+	}
+	if n, _ := redis.Int(conn.Do("LLEN", g.queueKey("delayed_queue", QUEUE_PENDING))); n != 1 {
+		t.Fatalf("expected job to be pending, got %d", n)
+	}
+}
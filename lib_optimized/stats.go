@@ -0,0 +1,182 @@
+package lib
+
+import (
+	"time"
+
+	"github.com/garyburd/redigo/redis"
+)
+
+const (
+	DAILY                        = ":daily:"
+	DAILY_DATE_FORMAT            = "2006-01-02"
+	DEFAULT_STATS_RETENTION_DAYS = 30
+)
+
+// Counters are the lifetime job counts kept globally and per queue.
+// Processed counts every finished attempt, succeeded or failed; Retried
+// and Dead count the failures that were rescheduled or dead-lettered.
+type Counters struct {
+	Enqueued  int `json:"enqueued"`
+	Processed int `json:"processed"`
+	Succeeded int `json:"succeeded"`
+	Failed    int `json:"failed"`
+	Retried   int `json:"retried"`
+	Dead      int `json:"dead"`
+}
+
+var counterNames = []string{STAT_ENQUEUED, STAT_PROCESSED, STAT_SUCCEEDED, STAT_FAILED, STAT_RETRIED, STAT_DEAD}
+
+func (g *Gores) queueStatKey(name, queue string) string {
+	return g.prefix + name + ":" + queue
+}
+
+// taskStatKey returns the key of a per-task counter on queue. Only
+// STAT_PROCESSED and STAT_FAILED are kept per task.
+func (g *Gores) taskStatKey(name, queue, task string) string {
+	return g.queueStatKey(name, queue) + ":" + task
+}
+
+// dailyStatKey returns the key of queue's counter for the UTC day of t.
+// Only STAT_PROCESSED and STAT_FAILED are kept per day.
+func (g *Gores) dailyStatKey(name, queue string, t time.Time) string {
+	return g.prefix + name + DAILY + queue + ":" + t.UTC().Format(DAILY_DATE_FORMAT)
+}
+
+// statsRetention returns how many days daily counters are kept.
+func (g *Gores) statsRetention() int {
+	if g.config != nil && g.config.StatsRetention > 0 {
+		return g.config.StatsRetention
+	}
+	return DEFAULT_STATS_RETENTION_DAYS
+}
+
+// statUpdate holds the counter keys a settling script increments. Daily
+// counters also get an expiry of ttl seconds.
+type statUpdate struct {
+	keys  []interface{}
+	daily []interface{}
+	ttl   int
+}
+
+// statKeys returns the global and per-queue keys of each named counter,
+// plus today's daily keys and the per-task keys for task if it is set.
+func (g *Gores) statKeys(queue, task string, names ...string) statUpdate {
+	var s statUpdate
+	now := time.Now()
+	for _, name := range names {
+		s.keys = append(s.keys, g.prefix+name, g.queueStatKey(name, queue))
+		if name != STAT_PROCESSED && name != STAT_FAILED {
+			continue
+		}
+		if task != "" {
+			s.keys = append(s.keys, g.taskStatKey(name, queue, task))
+		}
+		s.daily = append(s.daily, g.dailyStatKey(name, queue, now))
+	}
+	s.ttl = g.statsRetention() * 24 * 3600
+	return s
+}
+
+// luaIncrStats is the tail of every settling script: it increments the
+// counters from KEYS[first] on, the last ARGV[#ARGV - 1] of which are daily
+// counters that expire ARGV[#ARGV] seconds after their last increment.
+const luaIncrStats = `
+	local daily = tonumber(ARGV[#ARGV - 1])
+	for i = first, #KEYS do
+		redis.call('INCR', KEYS[i])
+		if i > #KEYS - daily then
+			redis.call('EXPIRE', KEYS[i], ARGV[#ARGV])
+		end
+	end
+`
+
+// counted returns the arguments of a settling script: the total key count,
+// its fixed keys, the counters of s, argv and the daily counter settings
+// luaIncrStats expects.
+func counted(keys []interface{}, s statUpdate, argv ...interface{}) []interface{} {
+	args := make([]interface{}, 0, 3+len(keys)+len(s.keys)+len(s.daily)+len(argv))
+	args = append(args, len(keys)+len(s.keys)+len(s.daily))
+	args = append(args, keys...)
+	args = append(args, s.keys...)
+	args = append(args, s.daily...)
+	args = append(args, argv...)
+	return append(args, len(s.daily), s.ttl)
+}
+
+// sendCounters queues reads of the global counters, or of queue's when
+// queue is set, as part of a transaction on conn.
+func (g *Gores) sendCounters(conn redis.Conn, queue string) {
+	for _, name := range counterNames {
+		if queue == "" {
+			conn.Send("GET", g.prefix+name)
+		} else {
+			conn.Send("GET", g.queueStatKey(name, queue))
+		}
+	}
+}
+
+// parseCounters reads the replies queued by sendCounters.
+func parseCounters(replies []interface{}) Counters {
+	n := make([]int, len(counterNames))
+	for i := range n {
+		n[i], _ = redis.Int(replies[i], nil)
+	}
+	return Counters{Enqueued: n[0], Processed: n[1], Succeeded: n[2], Failed: n[3], Retried: n[4], Dead: n[5]}
+}
+
+// QueueCounters returns the lifetime counters of queue.
+func (g *Gores) QueueCounters(queue string) (Counters, error) {
+	conn := g.pool.Get()
+	defer conn.Close()
+
+	conn.Send("MULTI")
+	g.sendCounters(conn, queue)
+	replies, err := redis.Values(conn.Do("EXEC"))
+	if err != nil {
+		return Counters{}, err
+	}
+	return parseCounters(replies), nil
+}
+
+// DailyStats are a queue's counts for one UTC day.
+type DailyStats struct {
+	Date      time.Time `json:"date"`
+	Processed int       `json:"processed"`
+	Failed    int       `json:"failed"`
+}
+
+// History returns queue's daily counts for every UTC day from from to to,
+// inclusive. The range is clamped to the days counters can still exist for:
+// none older than the stats retention and none after today.
+func (g *Gores) History(queue string, from, to time.Time) ([]DailyStats, error) {
+	today := time.Now().UTC().Truncate(24 * time.Hour)
+	from = from.UTC().Truncate(24 * time.Hour)
+	if oldest := today.AddDate(0, 0, -g.statsRetention()); from.Before(oldest) {
+		from = oldest
+	}
+	to = to.UTC().Truncate(24 * time.Hour)
+	if to.After(today) {
+		to = today
+	}
+	var days []DailyStats
+	var keys []interface{}
+	for d := from; !d.After(to); d = d.AddDate(0, 0, 1) {
+		days = append(days, DailyStats{Date: d})
+		keys = append(keys, g.dailyStatKey(STAT_PROCESSED, queue, d), g.dailyStatKey(STAT_FAILED, queue, d))
+	}
+	if len(days) == 0 {
+		return nil, nil
+	}
+
+	conn := g.pool.Get()
+	defer conn.Close()
+
+	counts, err := redis.Ints(conn.Do("MGET", keys...))
+	if err != nil {
+		return nil, err
+	}
+	for i := range days {
+		days[i].Processed, days[i].Failed = counts[2*i], counts[2*i+1]
+	}
+	return days, nil
+}
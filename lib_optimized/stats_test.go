@@ -0,0 +1,99 @@
+package lib
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/garyburd/redigo/redis"
+)
+
+func TestQueueCounters(t *testing.T) {
+	cfg := newTestConfig()
+	g := NewGores(cfg)
+	defer g.Close()
+
+	const queue = "stats_queue"
+	conn := g.pool.Get()
+	defer conn.Close()
+	_, _ = conn.Do("DEL", g.queueKey(queue, QUEUE_PENDING), g.queueKey(queue, QUEUE_RETRY), g.queueKey(queue, QUEUE_DEADLETTER))
+	for _, name := range counterNames {
+		_, _ = conn.Do("DEL", g.queueStatKey(name, queue))
+	}
+
+	ctx := context.Background()
+	mux := NewServeMux()
+	mux.HandleFunc("Ok", func(ctx context.Context, job *Job) error { return nil })
+	mux.HandleFunc("Flaky", func(ctx context.Context, job *Job) error { return errors.New("boom") })
+	mux.HandleFunc("Broken", func(ctx context.Context, job *Job) error { return NonRetryable(errors.New("bad")) })
+
+	batch := []*Job{NewTask("Ok", nil), NewTask("Flaky", nil), NewTask("Broken", nil)}
+	for _, job := range batch {
+		job.Queue = queue
+	}
+	if err := g.EnqueueJobs(ctx, batch); err != nil {
+		t.Fatalf("EnqueueJobs: %v", err)
+	}
+	for range batch {
+		_, data, _ := g.fetch(conn, testWorker, []string{queue})
+		g.handle(ctx, conn, WorkerConfig{}, testWorker, queue, data, mux)
+	}
+
+	got, err := g.QueueCounters(queue)
+	if err != nil {
+		t.Fatalf("QueueCounters: %v", err)
+	}
+	want := Counters{Enqueued: 3, Processed: 3, Succeeded: 1, Failed: 2, Retried: 1, Dead: 1}
+	if got != want {
+		t.Fatalf("expected %+v, got %+v", want, got)
+	}
+}
+
+func TestHistory(t *testing.T) {
+	cfg := newTestConfig()
+	g := NewGores(cfg)
+	defer g.Close()
+
+	const queue = "history_queue"
+	conn := g.pool.Get()
+	defer conn.Close()
+	now := time.Now()
+	yesterday := now.AddDate(0, 0, -1)
+	_, _ = conn.Do("DEL", g.queueKey(queue, QUEUE_PENDING), g.dailyStatKey(STAT_PROCESSED, queue, now), g.dailyStatKey(STAT_FAILED, queue, now))
+	_, _ = conn.Do("SET", g.dailyStatKey(STAT_PROCESSED, queue, yesterday), 7)
+
+	ctx := context.Background()
+	mux := NewServeMux()
+	mux.HandleFunc("Ok", func(ctx context.Context, job *Job) error { return nil })
+	mux.HandleFunc("Broken", func(ctx context.Context, job *Job) error { return NonRetryable(errors.New("bad")) })
+	for _, name := range []string{"Ok", "Ok", "Broken"} {
+		if _, err := g.EnqueueJob(ctx, NewTask(name, nil), WithQueue(queue)); err != nil {
+			t.Fatalf("enqueue: %v", err)
+		}
+		_, data, _ := g.fetch(conn, testWorker, []string{queue})
+		g.handle(ctx, conn, WorkerConfig{}, testWorker, queue, data, mux)
+	}
+
+	days, err := g.History(queue, now.AddDate(0, 0, -2), now)
+	if err != nil {
+		t.Fatalf("History: %v", err)
+	}
+	if len(days) != 3 {
+		t.Fatalf("expected 3 days, got %+v", days)
+	}
+	if days[0].Processed != 0 || days[1].Processed != 7 || days[2].Processed != 3 || days[2].Failed != 1 {
+		t.Fatalf("unexpected history: %+v", days)
+	}
+	if ttl, _ := redis.Int(conn.Do("TTL", g.dailyStatKey(STAT_PROCESSED, queue, now))); ttl <= 0 {
+		t.Fatalf("expected daily counter to expire, got TTL %d", ttl)
+	}
+
+	days, err = g.History(queue, now.AddDate(-100, 0, 0), now.AddDate(0, 0, 7))
+	if err != nil {
+		t.Fatalf("History: %v", err)
+	}
+	if len(days) != g.statsRetention()+1 || days[len(days)-1].Processed != 3 {
+		t.Fatalf("expected the range clamped to %d days ending today, got %d", g.statsRetention()+1, len(days))
+	}
+}
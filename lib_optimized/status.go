@@ -0,0 +1,174 @@
+package lib
+
+import (
+	"context"
+	"errors"
+	"strconv"
+	"time"
+
+	"github.com/garyburd/redigo/redis"
+)
+
+const (
+	JOB                      = "job:"
+	DEFAULT_RESULT_RETENTION = 24 * 3600
+
+	STATUS_QUEUED    = "queued"
+	STATUS_ACTIVE    = "active"
+	STATUS_RETRYING  = "retrying"
+	STATUS_SUCCEEDED = "succeeded"
+	STATUS_FAILED    = "failed"
+	STATUS_DEAD      = "dead"
+	STATUS_CANCELLED = "cancelled"
+)
+
+// ErrJobNotFound is returned for a job ID with no status record, either
+// because it was never enqueued or because its record has expired.
+var ErrJobNotFound = errors.New("job not found")
+
+// ErrNoResult is returned by GetJobResult for a job without a stored result.
+var ErrNoResult = errors.New("job has no result")
+
+// JobStatus is the record kept for every enqueued job. State is one of the
+// STATUS_ constants: STATUS_FAILED marks a job dead-lettered by a
+// non-retryable error, STATUS_DEAD one that exhausted its retries. Result
+// holds the value the handler passed to SetResult, encoded with the
+// worker's codec named by ResultCodec, and Progress (0-100) and Message the last values the
+// handler passed to ReportProgress.
+type JobStatus struct {
+	ID          string    `json:"id"`
+	Name        string    `json:"name"`
+	Queue       string    `json:"queue"`
+	State       string    `json:"state"`
+	RetryCount  int       `json:"retry_count"`
+	Error       string    `json:"error,omitempty"`
+	Result      []byte    `json:"result,omitempty"`
+	ResultCodec string    `json:"result_codec,omitempty"`
+	Progress    int       `json:"progress"`
+	Message     string    `json:"message,omitempty"`
+	EnqueuedAt  time.Time `json:"enqueued_at"`
+	StartedAt   time.Time `json:"started_at"`
+	FinishedAt  time.Time `json:"finished_at"`
+}
+
+func (g *Gores) statusKey(id string) string {
+	return g.prefix + JOB + id
+}
+
+// resultRetention returns how many seconds a status record outlives the
+// job finishing.
+func (g *Gores) resultRetention() int {
+	if g.config != nil && g.config.ResultRetention > 0 {
+		return g.config.ResultRetention
+	}
+	return DEFAULT_RESULT_RETENTION
+}
+
+// sendStatus queues an update of job id's status record on conn; it is
+// written with the connection's next command. The record of a job that has
+// yet to finish does not expire, however long it waits.
+func (g *Gores) sendStatus(conn redis.Conn, id string, fields ...interface{}) {
+	conn.Send("HSET", append([]interface{}{g.statusKey(id)}, fields...)...)
+}
+
+// finishStatus is sendStatus for a job that will not run again: the copy of
+// its payload kept for CancelJob is dropped and the record expires after
+// the result retention.
+func (g *Gores) finishStatus(conn redis.Conn, id string, fields ...interface{}) {
+	key := g.statusKey(id)
+	g.sendStatus(conn, id, fields...)
+	conn.Send("HDEL", key, "payload")
+	conn.Send("EXPIRE", key, g.resultRetention())
+}
+
+// GetJobStatus returns the status record of job id.
+func (g *Gores) GetJobStatus(id string) (*JobStatus, error) {
+	conn := g.pool.Get()
+	defer conn.Close()
+
+	fields, err := redis.StringMap(conn.Do("HGETALL", g.statusKey(id)))
+	if err != nil {
+		return nil, err
+	}
+	if len(fields) == 0 {
+		return nil, ErrJobNotFound
+	}
+	retries, _ := strconv.Atoi(fields["retry_count"])
+	progress, _ := strconv.Atoi(fields["progress"])
+	s := &JobStatus{
+		ID:          id,
+		Name:        fields["name"],
+		Queue:       fields["queue"],
+		State:       fields["state"],
+		RetryCount:  retries,
+		Error:       fields["error"],
+		Progress:    progress,
+		Message:     fields["message"],
+		ResultCodec: fields["result_codec"],
+		EnqueuedAt:  unixField(fields["enqueued_at"]),
+		StartedAt:   unixField(fields["started_at"]),
+		FinishedAt:  unixField(fields["finished_at"]),
+	}
+	if r, ok := fields["result"]; ok {
+		s.Result = []byte(r)
+	}
+	return s, nil
+}
+
+// GetJobResult decodes the result stored for job id into v with the codec
+// that encoded it, or g's own for results that do not name one.
+func (g *Gores) GetJobResult(id string, v interface{}) error {
+	s, err := g.GetJobStatus(id)
+	if err != nil {
+		return err
+	}
+	if s.Result == nil {
+		return ErrNoResult
+	}
+	c := g.codec
+	if s.ResultCodec != "" {
+		if c, err = codecByName(s.ResultCodec); err != nil {
+			return err
+		}
+	}
+	return c.Unmarshal(s.Result, v)
+}
+
+func unixField(s string) time.Time {
+	secs, err := strconv.ParseInt(s, 10, 64)
+	if err != nil || secs == 0 {
+		return time.Time{}
+	}
+	return time.Unix(secs, 0)
+}
+
+type jobRunKey struct{}
+
+// jobRun is what a handler's context knows about the job it is running.
+type jobRun struct {
+	g      *Gores
+	id     string
+	result []byte
+	codec  string // name of the codec that encoded result
+}
+
+func withJobRun(ctx context.Context, r *jobRun) context.Context {
+	return context.WithValue(ctx, jobRunKey{}, r)
+}
+
+// SetResult records v as the return value of the job running under ctx. It
+// is encoded with the worker's codec and stored in the job's status record,
+// along with the codec's name, once the job succeeds. Calling it outside a handler is an error.
+func SetResult(ctx context.Context, v interface{}) error {
+	r, ok := ctx.Value(jobRunKey{}).(*jobRun)
+	if !ok {
+		return errors.New("gores: SetResult called outside a job")
+	}
+	c := codecFromContext(ctx)
+	data, err := c.Marshal(v)
+	if err != nil {
+		return err
+	}
+	r.result, r.codec = data, codecName(c)
+	return nil
+}
@@ -0,0 +1,123 @@
+package lib
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/garyburd/redigo/redis"
+)
+
+func TestJobStatusLifecycle(t *testing.T) {
+	cfg := newTestConfig()
+	g := NewGores(cfg)
+	defer g.Close()
+
+	const queue = "status_queue"
+	conn := g.pool.Get()
+	defer conn.Close()
+	_, _ = conn.Do("DEL", g.queueKey(queue, QUEUE_PENDING), g.queueKey(queue, QUEUE_RETRY), g.queueKey(queue, QUEUE_DEADLETTER))
+
+	ctx := context.Background()
+	mux := NewServeMux()
+	mux.HandleFunc("Sum", func(ctx context.Context, job *Job) error {
+		return SetResult(ctx, map[string]int{"sum": 3})
+	})
+	mux.HandleFunc("Flaky", func(ctx context.Context, job *Job) error { return errors.New("boom") })
+	mux.HandleFunc("Broken", func(ctx context.Context, job *Job) error { return NonRetryable(errors.New("bad input")) })
+
+	ttl := func(id string) int {
+		t.Helper()
+		n, err := redis.Int(conn.Do("TTL", g.statusKey(id)))
+		if err != nil {
+			t.Fatalf("TTL: %v", err)
+		}
+		return n
+	}
+	run := func(name string) *JobStatus {
+		t.Helper()
+		id, err := g.EnqueueJob(ctx, NewTask(name, nil), WithQueue(queue))
+		if err != nil {
+			t.Fatalf("enqueue %s: %v", name, err)
+		}
+		if s, err := g.GetJobStatus(id); err != nil || s.State != STATUS_QUEUED || s.Name != name || s.EnqueuedAt.IsZero() {
+			t.Fatalf("expected queued status for %s, got %+v (%v)", name, s, err)
+		}
+		if n := ttl(id); n != -1 {
+			t.Fatalf("expected the status of queued job %s not to expire, got TTL %d", name, n)
+		}
+		_, data, err := g.fetch(conn, testWorker, []string{queue})
+		if err != nil || data == nil {
+			t.Fatalf("fetch %s: %v", name, err)
+		}
+		g.handle(ctx, conn, WorkerConfig{}, testWorker, queue, data, mux)
+		s, err := g.GetJobStatus(id)
+		if err != nil {
+			t.Fatalf("status %s: %v", name, err)
+		}
+		return s
+	}
+
+	s := run("Sum")
+	if s.State != STATUS_SUCCEEDED || s.StartedAt.IsZero() || s.FinishedAt.IsZero() {
+		t.Fatalf("expected succeeded status, got %+v", s)
+	}
+	var result map[string]int
+	if err := g.GetJobResult(s.ID, &result); err != nil || result["sum"] != 3 {
+		t.Fatalf("expected result sum=3, got %v (%v)", result, err)
+	}
+	if n := ttl(s.ID); n <= 0 || n > g.resultRetention() {
+		t.Fatalf("expected the finished status to expire after the result retention, got TTL %d", n)
+	}
+
+	if s := run("Flaky"); s.State != STATUS_RETRYING || s.Error != "boom" || s.RetryCount != 1 || ttl(s.ID) != -1 {
+		t.Fatalf("expected retrying status that does not expire, got %+v", s)
+	}
+	if s := run("Broken"); s.State != STATUS_FAILED || s.Error != "bad input" || ttl(s.ID) <= 0 {
+		t.Fatalf("expected failed status that expires, got %+v", s)
+	}
+
+	if _, err := g.GetJobStatus("no-such-job"); !errors.Is(err, ErrJobNotFound) {
+		t.Fatalf("expected ErrJobNotFound, got %v", err)
+	}
+	if err := SetResult(ctx, 1); err == nil {
+		t.Fatal("expected SetResult outside a job to fail")
+	}
+}
+
+func TestReportProgress(t *testing.T) {
+	cfg := newTestConfig()
+	g := NewGores(cfg)
+	defer g.Close()
+
+	const queue = "status_queue"
+	conn := g.pool.Get()
+	defer conn.Close()
+	_, _ = conn.Do("DEL", g.queueKey(queue, QUEUE_PENDING))
+
+	ctx := context.Background()
+	id, err := g.EnqueueJob(ctx, NewTask("Transcode", nil), WithQueue(queue))
+	if err != nil {
+		t.Fatalf("enqueue: %v", err)
+	}
+	mux := NewServeMux()
+	mux.HandleFunc("Transcode", func(ctx context.Context, job *Job) error {
+		if err := ReportProgress(ctx, 101, ""); err == nil {
+			t.Error("expected out-of-range progress to fail")
+		}
+		return ReportProgress(ctx, 40, "pass 1 of 2")
+	})
+	_, data, _ := g.fetch(conn, testWorker, []string{queue})
+	g.handle(ctx, conn, WorkerConfig{}, testWorker, queue, data, mux)
+
+	s, err := g.GetJobStatus(id)
+	if err != nil {
+		t.Fatalf("status: %v", err)
+	}
+	if s.State != STATUS_SUCCEEDED || s.Progress != 40 || s.Message != "pass 1 of 2" {
+		t.Fatalf("expected recorded progress, got %+v", s)
+	}
+	if err := ReportProgress(ctx, 10, ""); err == nil {
+		t.Fatal("expected ReportProgress outside a job to fail")
+	}
+}
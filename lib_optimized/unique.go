@@ -0,0 +1,98 @@
+package lib
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/vmihailenco/msgpack/v5"
+)
+
+const (
+	UNIQUE             = "unique:"
+	DEFAULT_UNIQUE_TTL = 24 * time.Hour
+)
+
+// ErrDuplicateJob is matched by the *DuplicateJobError returned when a
+// unique job is enqueued while another job holds its uniqueness lock, or
+// when a job is enqueued with the ID of one that already exists.
+var ErrDuplicateJob = errors.New("duplicate job")
+
+// DuplicateJobError reports the uniqueness key, or for a reused ID the ID,
+// of a rejected job.
+type DuplicateJobError struct {
+	UniqueKey string
+	ID        string
+}
+
+func (e *DuplicateJobError) Error() string {
+	if e.ID != "" {
+		return fmt.Sprintf("duplicate job: job %s already exists", e.ID)
+	}
+	return fmt.Sprintf("duplicate job: unique key %q is locked", e.UniqueKey)
+}
+
+// duplicateError maps an enqueueScript result for job to its error.
+func duplicateError(stored int, job *Job) error {
+	switch stored {
+	case 0:
+		return &DuplicateJobError{UniqueKey: job.UniqueKey}
+	case -1:
+		return &DuplicateJobError{ID: job.ID}
+	}
+	return nil
+}
+
+func (e *DuplicateJobError) Is(target error) bool {
+	return target == ErrDuplicateJob
+}
+
+// prepareUnique resolves the uniqueness settings of a job about to be
+// enqueued: an explicit key without a TTL gets DEFAULT_UNIQUE_TTL, and a
+// TTL without a key gets a key hashed from the job's name, queue and args
+// or payload.
+func (j *Job) prepareUnique() error {
+	if j.UniqueKey == "" && j.UniqueTTL == 0 {
+		return nil
+	}
+	if j.UniqueTTL == 0 {
+		j.UniqueTTL = DEFAULT_UNIQUE_TTL
+	}
+	if j.UniqueKey != "" {
+		return nil
+	}
+
+	var buf bytes.Buffer
+	enc := msgpack.NewEncoder(&buf)
+	enc.SetSortMapKeys(true)
+	if err := enc.Encode(j.Args); err != nil {
+		return fmt.Errorf("%w: hash args: %v", ErrInvalidJob, err)
+	}
+	h := sha256.New()
+	fmt.Fprintf(h, "%s\x00%s\x00", j.Name, j.Queue)
+	h.Write(buf.Bytes())
+	h.Write(j.Payload)
+	j.UniqueKey = hex.EncodeToString(h.Sum(nil))
+	return nil
+}
+
+// uniqueTTLSeconds returns the lock TTL in whole seconds, rounded up; 0
+// means the job is not unique.
+func (j *Job) uniqueTTLSeconds() int64 {
+	if j.UniqueKey == "" {
+		return 0
+	}
+	return int64((j.UniqueTTL + time.Second - 1) / time.Second)
+}
+
+// uniqueLockKey returns the Redis key of the job's uniqueness lock, or ""
+// for jobs that are not unique.
+func (g *Gores) uniqueLockKey(job *Job) string {
+	if job.UniqueKey == "" {
+		return ""
+	}
+	return g.prefix + UNIQUE + job.UniqueKey
+}
@@ -0,0 +1,103 @@
+package lib
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/garyburd/redigo/redis"
+)
+
+func TestPrepareUniqueHashesContent(t *testing.T) {
+	a := &Job{Name: "Report", Queue: "reports", Args: map[string]interface{}{"id": 42, "fmt": "pdf"}, UniqueTTL: time.Minute}
+	b := &Job{Name: "Report", Queue: "reports", Args: map[string]interface{}{"fmt": "pdf", "id": 42}, UniqueTTL: time.Minute}
+	c := &Job{Name: "Report", Queue: "reports", Args: map[string]interface{}{"id": 43, "fmt": "pdf"}, UniqueTTL: time.Minute}
+	for _, j := range []*Job{a, b, c} {
+		if err := j.prepareUnique(); err != nil {
+			t.Fatalf("prepareUnique: %v", err)
+		}
+	}
+	if a.UniqueKey == "" || a.UniqueKey != b.UniqueKey {
+		t.Fatalf("expected equal keys for equal content, got %q and %q", a.UniqueKey, b.UniqueKey)
+	}
+	if a.UniqueKey == c.UniqueKey {
+		t.Fatal("expected different keys for different args")
+	}
+
+	explicit := &Job{UniqueKey: "report:42"}
+	_ = explicit.prepareUnique()
+	if explicit.UniqueTTL != DEFAULT_UNIQUE_TTL || explicit.uniqueTTLSeconds() != int64(DEFAULT_UNIQUE_TTL/time.Second) {
+		t.Fatalf("expected default TTL, got %v", explicit.UniqueTTL)
+	}
+}
+
+func TestUniqueJobRejectsDuplicatesUntilAcked(t *testing.T) {
+	cfg := newTestConfig()
+	g := NewGores(cfg)
+	defer g.Close()
+
+	const queue = "unique_queue"
+	conn := g.pool.Get()
+	defer conn.Close()
+	_, _ = conn.Do("DEL", g.queueKey(queue, QUEUE_PENDING), g.prefix+UNIQUE+"report:42")
+
+	ctx := context.Background()
+	first := NewTask("Report", nil)
+	if _, err := g.EnqueueJob(ctx, first, WithQueue(queue), WithUniqueKey("report:42")); err != nil {
+		t.Fatalf("enqueue: %v", err)
+	}
+	_, err := g.EnqueueJob(ctx, NewTask("Report", nil), WithQueue(queue), WithUniqueKey("report:42"))
+	var dup *DuplicateJobError
+	if !errors.As(err, &dup) || !errors.Is(err, ErrDuplicateJob) || dup.UniqueKey != "report:42" {
+		t.Fatalf("expected DuplicateJobError, got %v", err)
+	}
+	batch := []*Job{NewTask("Report", nil)}
+	WithQueue(queue)(batch[0])
+	WithUniqueKey("report:42")(batch[0])
+	if err := g.EnqueueJobs(ctx, batch); !errors.Is(err, ErrDuplicateJob) {
+		t.Fatalf("expected batch duplicate to be rejected, got %v", err)
+	}
+	if n, _ := redis.Int(conn.Do("LLEN", g.queueKey(queue, QUEUE_PENDING))); n != 1 {
+		t.Fatalf("expected only the first job to be pending, got %d", n)
+	}
+
+	_, data, err := g.fetch(conn, testWorker, []string{queue})
+	if err != nil || data == nil {
+		t.Fatalf("fetch: %v", err)
+	}
+	mux := NewServeMux()
+	mux.HandleFunc("Report", func(ctx context.Context, job *Job) error { return nil })
+	g.handle(ctx, conn, WorkerConfig{}, testWorker, queue, data, mux)
+
+	if _, err := g.EnqueueJob(ctx, NewTask("Report", nil), WithQueue(queue), WithUniqueKey("report:42")); err != nil {
+		t.Fatalf("expected lock to be released after success, got %v", err)
+	}
+}
+
+func TestUniqueLockSurvivesRetry(t *testing.T) {
+	cfg := newTestConfig()
+	g := NewGores(cfg)
+	defer g.Close()
+
+	const queue = "unique_queue"
+	conn := g.pool.Get()
+	defer conn.Close()
+	_, _ = conn.Do("DEL", g.queueKey(queue, QUEUE_PENDING), g.queueKey(queue, QUEUE_RETRY), g.prefix+UNIQUE+"flaky")
+
+	ctx := context.Background()
+	if _, err := g.EnqueueJob(ctx, NewTask("Flaky", nil), WithQueue(queue), WithUniqueKey("flaky")); err != nil {
+		t.Fatalf("enqueue: %v", err)
+	}
+	_, data, _ := g.fetch(conn, testWorker, []string{queue})
+	mux := NewServeMux()
+	mux.HandleFunc("Flaky", func(ctx context.Context, job *Job) error { return errors.New("fail") })
+	g.handle(ctx, conn, WorkerConfig{}, testWorker, queue, data, mux)
+
+	if n, _ := redis.Int(conn.Do("ZCARD", g.queueKey(queue, QUEUE_RETRY))); n != 1 {
+		t.Fatalf("expected job on retry set, got %d", n)
+	}
+	if _, err := g.EnqueueJob(ctx, NewTask("Flaky", nil), WithQueue(queue), WithUniqueKey("flaky")); !errors.Is(err, ErrDuplicateJob) {
+		t.Fatalf("expected lock to be held while retrying, got %v", err)
+	}
+}
@@ -2,20 +2,62 @@ package lib
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"log"
-	"math"
 	"os"
 	"os/signal"
 	"runtime"
+	"runtime/debug"
 	"sync"
 	"syscall"
 	"time"
+
+	"github.com/garyburd/redigo/redis"
 )
 
-func (g *Gores) StartWorkers(n int, tasks map[string]func(map[string]interface{}) error) {
+// StartWorkers runs n workers over the queues listed in the config's worker
+// section, wrapping every task in mws.
+func (g *Gores) StartWorkers(n int, tasks map[string]func(map[string]interface{}) error, mws ...Middleware) {
+	var cfg WorkerConfig
+	if g.config != nil {
+		cfg = g.config.Worker
+	}
+	mux := muxFromTasks(tasks)
+	mux.Use(mws...)
+	g.StartWorkerPool(n, cfg, mux)
+}
+
+// StartWorkerPool runs n workers that pass jobs from every queue in
+// cfg.Queues to h, typically a *ServeMux, until
+// SIGINT or SIGTERM is received. Each worker registers itself under a
+// "host:pid:run:index" identity, unique to this call, and keeps its own
+// processing list per queue; cfg.Priority controls the order queues are
+// drained in. On shutdown, running jobs get cfg.ShutdownTimeout seconds to
+// finish before their contexts are cancelled and they are returned to
+// their pending lists.
+// Workers keep heartbeating until then and deregister once drained.
+// Jobs cancelled through CancelJob have their contexts cancelled too.
+func (g *Gores) StartWorkerPool(n int, cfg WorkerConfig, h Handler) {
+	queues := cfg.Queues
+	if len(queues) == 0 {
+		queues = []string{DEFAULT_QUEUE}
+	}
+	maxRecoveries := cfg.MaxRecoveries
+	if maxRecoveries == 0 {
+		maxRecoveries = DEFAULT_MAX_RECOVERIES
+	}
+
+	run := NewULIDGenerator().NewID()
+	workerIDs := make([]string, n)
+	for i := range workerIDs {
+		workerIDs[i] = workerIdentity(run, i)
+	}
+
 	ctx, cancel := context.WithCancel(context.Background())
 	defer cancel()
+	jobCtx, cancelJobs := context.WithCancel(context.Background())
+	defer cancelJobs()
 
 	sigs := make(chan os.Signal, 1)
 	signal.Notify(sigs, syscall.SIGINT, syscall.SIGTERM)
@@ -23,29 +65,56 @@ func (g *Gores) StartWorkers(n int, tasks map[string]func(map[string]interface{}
 		<-sigs
 		log.Println("Received termination signal, shutting down workers gracefully...")
 		cancel()
+		time.AfterFunc(time.Duration(cfg.ShutdownTimeout)*time.Second, cancelJobs)
+	}()
+
+	// Heartbeats outlive ctx so that jobs still running during the shutdown
+	// grace period are not reaped by other processes.
+	beatCtx, stopBeats := context.WithCancel(context.Background())
+	beating := make(chan struct{})
+	go func() {
+		defer close(beating)
+		g.heartbeat(beatCtx, workerIDs, queues, time.Now())
 	}()
 
 	numCPU := runtime.NumCPU()
-	var wg sync.WaitGroup
+	var wg, workers sync.WaitGroup
 
-	for i := 0; i < n; i++ {
+	for _, run := range []func(){
+		func() { g.runScheduler(ctx, queues) },
+		func() { g.runReaper(ctx, maxRecoveries) },
+		func() { g.runCanceller(ctx) },
+	} {
 		wg.Add(1)
+		go func(run func()) {
+			defer wg.Done()
+			run()
+		}(run)
+	}
+
+	for i := 0; i < n; i++ {
+		workers.Add(1)
 		core := i % numCPU
 		go func(workerID, coreID int) {
-			defer wg.Done()
+			defer workers.Done()
 			runtime.LockOSThread()
 			defer runtime.UnlockOSThread()
 
+			id := workerIDs[workerID]
 			conn := g.pool.Get()
-			defer conn.Close()
+			defer func() { conn.Close() }()
+			if err := g.register(conn, id, workerID, queues); err != nil {
+				log.Printf("Could not register worker %s: %v", id, err)
+			}
 
+			selector := newQueueSelector(cfg, queues, uint64(workerID))
 			for {
 				select {
 				case <-ctx.Done():
 					return
 				default:
-					reply, err := conn.Do("BRPOPLPUSH", g.prefix+"demo_queue"+QUEUE_PENDING, g.prefix+"demo_queue"+QUEUE_PROCESS, 1)
-					if err != nil || reply == nil {
+					queue, data, err := g.fetch(conn, id, selector.order())
+					if err != nil || data == nil {
 						if err != nil {
 							conn.Close()
 							conn = g.pool.Get()
@@ -54,38 +123,225 @@ func (g *Gores) StartWorkers(n int, tasks map[string]func(map[string]interface{}
 						continue
 					}
 
-					data := reply.([]byte)
-					if err := g.processJob(data, tasks); err != nil {
-						log.Printf("Worker %d failed job after retries: %v", workerID, err)
-						_, _ = conn.Do("LPUSH", g.prefix+"demo_queue_deadletter", data)
-					}
-					_, _ = conn.Do("LREM", g.prefix+"demo_queue"+QUEUE_PROCESS, 1, data)
+					g.handle(jobCtx, conn, cfg, id, queue, data, h)
 				}
 			}
 		}(i, core)
 	}
 
+	workers.Wait()
+	stopBeats()
+	<-beating
+	conn := g.pool.Get()
+	for _, id := range workerIDs {
+		if err := g.deregister(conn, id); err != nil {
+			log.Printf("Could not deregister worker %s: %v", id, err)
+		}
+	}
+	conn.Close()
+
 	wg.Wait()
 	log.Println("All workers shut down.")
 }
 
-func (g *Gores) processJob(data []byte, tasks map[string]func(map[string]interface{}) error) error {
+// luaFetch moves the oldest job of the first non-empty pending list among
+// KEYS[1], KEYS[3], ... onto the processing list following it and returns
+// the list's position among them, from 1, and the job. It returns nil when
+// every list is empty.
+const luaFetch = `
+	for i = 1, #KEYS, 2 do
+		local data = redis.call('RPOPLPUSH', KEYS[i], KEYS[i + 1])
+		if data then
+			return {(i + 1) / 2, data}
+		end
+	end
+	return nil
+`
+
+var fetchScript = redis.NewScript(-1, luaFetch)
+
+// fetch moves the next job from the first non-empty queue, taking its jobs
+// of the highest priority first, onto the worker's processing list for that
+// queue. When every queue is empty it blocks on the first one's default
+// priority for up to a second, returning nil data on timeout.
+func (g *Gores) fetch(conn redis.Conn, workerID string, queues []string) (string, []byte, error) {
+	args := []interface{}{len(queues) * (MAX_JOB_PRIORITY + 1) * 2}
+	for _, q := range queues {
+		for _, key := range g.priorityKeys(q, QUEUE_PENDING) {
+			args = append(args, key, g.processingKey(q, workerID))
+		}
+	}
+	reply, err := redis.Values(fetchScript.Do(conn, args...))
+	if err != nil && err != redis.ErrNil {
+		return "", nil, err
+	}
+	if len(reply) == 2 {
+		i, _ := redis.Int(reply[0], nil)
+		data, err := redis.Bytes(reply[1], nil)
+		return queues[(i-1)/(MAX_JOB_PRIORITY+1)], data, err
+	}
+	data, err := redis.Bytes(conn.Do("BRPOPLPUSH", g.queueKey(queues[0], QUEUE_PENDING), g.processingKey(queues[0], workerID), 1))
+	if err == redis.ErrNil {
+		return "", nil, nil
+	}
+	if err != nil {
+		return "", nil, err
+	}
+	return queues[0], data, nil
+}
+
+// handle runs a fetched job and settles it: acknowledged on success or
+// cancellation, requeued if shutdown interrupted it, otherwise retried or
+// dead-lettered.
+// The job's status record follows each step.
+func (g *Gores) handle(ctx context.Context, conn redis.Conn, cfg WorkerConfig, workerID, queue string, data []byte, h Handler) {
+	job, err := FromBytes(data)
+	if err != nil {
+		log.Printf("Worker %s could not decode job, quarantining it: %v", workerID, err)
+		if err := g.quarantine(conn, g.processingKey(queue, workerID), queue, data, err, STAT_PROCESSED, STAT_FAILED); err != nil {
+			log.Printf("Worker %s could not quarantine job: %v", workerID, err)
+		}
+		g.metrics.jobPoisoned(queue)
+		return
+	}
+
+	run := &jobRun{g: g, id: job.ID}
+	runCtx, cancel := context.WithCancelCause(ctx)
+	g.running.Store(job.ID, cancel)
+	g.sendStatus(conn, job.ID, "state", STATUS_ACTIVE, "started_at", time.Now().Unix(), "worker", workerID,
+		"progress", 0, "message", "")
+	// Wait for the write so it cannot overtake the handler's own updates.
+	// Cancellations published before the job was stored as running are
+	// only seen through the flag CancelJob left on its status record.
+	requested, err := g.cancelRequested(conn, job.ID)
+	if err != nil {
+		log.Printf("Worker %s could not update status of job %s: %v", workerID, job.ID, err)
+	}
+	if requested {
+		cancel(ErrJobCancelled)
+	}
+	g.metrics.busy.Add(1)
+	start := time.Now()
+	runErr := runCtx.Err()
+	if runErr == nil {
+		runErr = g.processJob(withJobRun(runCtx, run), cfg, job, h)
+	}
+	elapsed := time.Since(start)
+	g.metrics.busy.Add(-1)
+	g.running.Delete(job.ID)
+	cancelled := errors.Is(context.Cause(runCtx), ErrJobCancelled)
+	cancel(nil)
+
+	outcome := OUTCOME_FAILED
+	switch {
+	case runErr == nil:
+		outcome = OUTCOME_SUCCEEDED
+		fields := []interface{}{"state", STATUS_SUCCEEDED, "finished_at", time.Now().Unix()}
+		if run.result != nil {
+			fields = append(fields, "result", run.result, "result_codec", run.codec)
+		}
+		g.finishStatus(conn, job.ID, fields...)
+		err = g.ack(conn, workerID, queue, data, job, STAT_PROCESSED, STAT_SUCCEEDED)
+	case ctx.Err() != nil:
+		outcome = OUTCOME_INTERRUPTED
+		log.Printf("Worker %s interrupted job %s by shutdown, requeueing", workerID, job.ID)
+		err = g.requeue(conn, workerID, queue, data)
+	case cancelled:
+		outcome = OUTCOME_CANCELLED
+		log.Printf("Worker %s cancelled job %s", workerID, job.ID)
+		g.finishStatus(conn, job.ID, "state", STATUS_CANCELLED, "finished_at", time.Now().Unix())
+		err = g.ack(conn, workerID, queue, data, job)
+	default:
+		log.Printf("Worker %s failed job %s: %v", workerID, job.ID, runErr)
+		err = g.retryOrBury(conn, workerID, queue, data, runErr)
+	}
+	if err != nil {
+		log.Printf("Worker %s could not settle job %s: %v", workerID, job.ID, err)
+	}
+	g.metrics.jobFinished(job, outcome, elapsed)
+
+	// A handler abandoned on timeout or cancellation may still use job.
+	if !errors.Is(runErr, context.DeadlineExceeded) && !errors.Is(runErr, context.Canceled) {
+		PutJob(job)
+	}
+}
+
+// requeue returns the job encoded as data from the worker's processing list
+// to the back of its pending list. The job is decoded afresh, since an
+// interrupted handler may still be using its copy.
+func (g *Gores) requeue(conn redis.Conn, workerID, queue string, data []byte) error {
 	job, err := FromBytes(data)
 	if err != nil {
 		return err
 	}
 	defer PutJob(job)
+	job.rejoin(time.Now())
+	out, err := job.ToBytes()
+	if err != nil {
+		return err
+	}
+	g.sendStatus(conn, job.ID, "state", STATUS_QUEUED, "payload", out)
+	_, err = moveScript.Do(conn, counted([]interface{}{g.processingKey(queue, workerID), g.priorityKey(queue, QUEUE_PENDING, job.priority()), ""},
+		statUpdate{}, data, out, "")...)
+	return err
+}
+
+// ack removes a finished job from the worker's processing list, releases
+// its uniqueness lock and increments the named counters.
+func (g *Gores) ack(conn redis.Conn, workerID, queue string, data []byte, job *Job, stats ...string) error {
+	_, err := ackScript.Do(conn, counted([]interface{}{g.processingKey(queue, workerID), g.uniqueLockKey(job)},
+		g.statKeys(queue, job.Name, stats...), data, job.ID)...)
+	return err
+}
 
-	fn, ok := tasks[job.Name]
-	if !ok {
-		return fmt.Errorf("task %s not found", job.Name)
+// processJob runs a single attempt of job. Failed attempts are rescheduled
+// by the caller rather than retried in place. Jobs past their deadline fail
+// without running; otherwise the handler runs under the job's timeout and
+// deadline. If it outlives its context, processJob returns the context's
+// error without waiting for it. A panicking handler fails the attempt with
+// a *PanicError instead of crashing the worker.
+func (g *Gores) processJob(ctx context.Context, cfg WorkerConfig, job *Job, h Handler) error {
+	if job.expired(time.Now()) {
+		return NonRetryable(fmt.Errorf("%w: job %s", ErrJobExpired, job.ID))
 	}
 
-	for r := 0; r < 3; r++ {
-		if err := fn(job.Args); err == nil {
-			return nil
-		}
-		time.Sleep(time.Duration(math.Pow(2, float64(r))) * time.Second)
+	ctx = withCodec(ctx, g.codec)
+	if timeout := cfg.jobTimeout(job); timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, timeout)
+		defer cancel()
+	}
+	if job.Deadline > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithDeadline(ctx, time.Unix(int64(job.Deadline), 0))
+		defer cancel()
+	}
+
+	done := make(chan error, 1)
+	go func() {
+		defer func() {
+			if r := recover(); r != nil {
+				done <- &PanicError{Value: r, Stack: debug.Stack()}
+			}
+		}()
+		done <- h.ProcessJob(ctx, job)
+	}()
+	select {
+	case err := <-done:
+		return err
+	case <-ctx.Done():
+		return fmt.Errorf("task %s: %w", job.Name, ctx.Err())
+	}
+}
+
+// jobTimeout returns the timeout for job: its own Timeout, else the
+// configured timeout for its task, else the pool default. Zero means none.
+func (cfg WorkerConfig) jobTimeout(job *Job) time.Duration {
+	if job.Timeout > 0 {
+		return job.Timeout
+	}
+	if secs, ok := cfg.TaskTimeouts[job.Name]; ok && secs > 0 {
+		return time.Duration(secs) * time.Second
 	}
-	return fmt.Errorf("max retries")
+	return time.Duration(cfg.Timeout) * time.Second
 }
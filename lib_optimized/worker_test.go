@@ -1,11 +1,30 @@
 package lib
 
 import (
+	"context"
 	"errors"
+	"strings"
 	"testing"
 	"time"
+
+	"github.com/garyburd/redigo/redis"
 )
 
+const testWorker = "test-worker"
+
+// processData decodes data and runs it through processJob, as the worker loop does.
+func processData(g *Gores, ctx context.Context, cfg WorkerConfig, data []byte, h Handler) error {
+	job, err := FromBytes(data)
+	if err != nil {
+		return err
+	}
+	err = g.processJob(ctx, cfg, job, h)
+	if !errors.Is(err, context.DeadlineExceeded) && !errors.Is(err, context.Canceled) {
+		PutJob(job)
+	}
+	return err
+}
+
 // Existing tests from your original file
 func TestProcessJobSuccess(t *testing.T) {
 	cfg := newTestConfig()
@@ -28,7 +47,7 @@ func TestProcessJobSuccess(t *testing.T) {
 		"PrintJob": func(args map[string]interface{}) error { return nil },
 	}
 
-	if err := g.processJob(data, tasks); err != nil {
+	if err := processData(g, context.Background(), WorkerConfig{}, data, muxFromTasks(tasks)); err != nil {
 		t.Fatalf("processJob: %v", err)
 	}
 }
@@ -38,17 +57,25 @@ func TestProcessJobRetryEventuallySucceeds(t *testing.T) {
 	g := NewGores(cfg)
 	defer g.Close()
 
+	const queue = "retry_queue"
+	conn := g.pool.Get()
+	defer conn.Close()
+	_, _ = conn.Do("DEL", g.queueKey(queue, QUEUE_PENDING), g.processingKey(queue, testWorker), g.queueKey(queue, QUEUE_RETRY))
+
 	j := &Job{
-		ID:    "2",
-		Name:  "FlakyJob",
-		Queue: "demo_queue",
-		Args:  map[string]interface{}{"id": float64(9)},
+		ID:      "2",
+		Name:    "FlakyJob",
+		Queue:   queue,
+		Args:    map[string]interface{}{"id": float64(9)},
+		Retry:   true,
+		Backoff: BACKOFF_CONSTANT,
 	}
 
 	data, err := j.ToBytes()
 	if err != nil {
 		t.Fatalf("marshal: %v", err)
 	}
+	_, _ = conn.Do("LPUSH", g.queueKey(queue, QUEUE_PENDING), data)
 
 	calls := 0
 	tasks := map[string]func(map[string]interface{}) error{
@@ -61,18 +88,64 @@ func TestProcessJobRetryEventuallySucceeds(t *testing.T) {
 		},
 	}
 
-	start := time.Now()
-	err = g.processJob(data, tasks)
-	if err != nil {
-		t.Fatalf("processJob with retries: %v", err)
+	// Each failed attempt is parked on the retry set; forwarding it from
+	// the future makes it pending again without waiting out the backoff.
+	now := time.Now()
+	for attempt := 1; ; attempt++ {
+		_, data, err = g.fetch(conn, testWorker, []string{queue})
+		if err != nil || data == nil {
+			t.Fatalf("attempt %d: fetch: %v", attempt, err)
+		}
+		if err := processData(g, context.Background(), WorkerConfig{}, data, muxFromTasks(tasks)); err == nil {
+			break
+		}
+		if err := g.retryOrBury(conn, testWorker, queue, data, errors.New("fail")); err != nil {
+			t.Fatalf("retryOrBury: %v", err)
+		}
+		if n, _ := redis.Int(conn.Do("ZCARD", g.queueKey(queue, QUEUE_RETRY))); n != 1 {
+			t.Fatalf("expected failed job on retry set, got %d", n)
+		}
+		now = now.Add(j.retryBackoff(attempt))
+		if _, err := g.forward(conn, queue, now); err != nil {
+			t.Fatalf("forward: %v", err)
+		}
 	}
 
-	if calls < 3 {
-		t.Fatalf("expected at least 3 attempts, got %d", calls)
+	if calls != 3 {
+		t.Fatalf("expected 3 attempts, got %d", calls)
+	}
+	job, err := FromBytes(data)
+	if err != nil {
+		t.Fatalf("unmarshal: %v", err)
 	}
+	if job.RetryCount != 2 {
+		t.Fatalf("expected RetryCount=2, got %d", job.RetryCount)
+	}
+}
+
+func TestRetryOrBuryExhausted(t *testing.T) {
+	cfg := newTestConfig()
+	g := NewGores(cfg)
+	defer g.Close()
+
+	const queue = "retry_queue"
+	conn := g.pool.Get()
+	defer conn.Close()
+	_, _ = conn.Do("DEL", g.processingKey(queue, testWorker), g.queueKey(queue, QUEUE_RETRY), g.queueKey(queue, QUEUE_DEADLETTER))
 
-	// Acknowledge elapsed backoff without asserting exact duration
-	_ = start
+	j := &Job{ID: "dead", Name: "FlakyJob", Queue: queue, Retry: true, RetryCount: DEFAULT_MAX_RETRIES}
+	data, _ := j.ToBytes()
+	_, _ = conn.Do("LPUSH", g.processingKey(queue, testWorker), data)
+
+	if err := g.retryOrBury(conn, testWorker, queue, data, errors.New("fail")); err != nil {
+		t.Fatalf("retryOrBury: %v", err)
+	}
+	if n, _ := redis.Int(conn.Do("LLEN", g.queueKey(queue, QUEUE_DEADLETTER))); n != 1 {
+		t.Fatalf("expected job on dead-letter list, got %d", n)
+	}
+	if n, _ := redis.Int(conn.Do("LLEN", g.processingKey(queue, testWorker))); n != 0 {
+		t.Fatalf("expected processing list to be empty, got %d", n)
+	}
 }
 
 func TestProcessJobErrorPaths(t *testing.T) {
@@ -87,7 +160,7 @@ func TestProcessJobErrorPaths(t *testing.T) {
 		"MissingTask": func(args map[string]interface{}) error { return errors.New("task not found") },
 	}
 
-	if err := g.processJob(data, tasks); err == nil {
+	if err := processData(g, context.Background(), WorkerConfig{}, data, muxFromTasks(tasks)); err == nil {
 		t.Fatal("Expected error not returned")
 	}
 }
@@ -114,7 +187,7 @@ func TestProcessJob_TaskNotFound(t *testing.T) {
 	// Empty tasks map (no handler for "UnknownTask")
 	tasks := map[string]func(map[string]interface{}) error{}
 
-	err = g.processJob(data, tasks)
+	err = processData(g, context.Background(), WorkerConfig{}, data, muxFromTasks(tasks))
 	if err == nil {
 		t.Fatal("expected error for unknown task")
 	}
@@ -123,6 +196,9 @@ func TestProcessJob_TaskNotFound(t *testing.T) {
 	if err.Error() != "task UnknownTask not found" {
 		t.Errorf("wrong error message, got: %v", err)
 	}
+	if !errors.Is(err, ErrTaskNotFound) || IsRetryable(err) {
+		t.Errorf("expected non-retryable ErrTaskNotFound, got: %v", err)
+	}
 }
 
 func TestProcessJob_NilArgs(t *testing.T) {
@@ -155,7 +231,7 @@ func TestProcessJob_NilArgs(t *testing.T) {
 		},
 	}
 
-	err = g.processJob(data, tasks)
+	err = processData(g, context.Background(), WorkerConfig{}, data, muxFromTasks(tasks))
 	if err != nil {
 		t.Errorf("processJob failed on nil args: %v", err)
 	}
@@ -189,8 +265,197 @@ func TestProcessJob_EmptyPayload(t *testing.T) {
 		},
 	}
 
-	err = g.processJob(data, tasks)
+	err = processData(g, context.Background(), WorkerConfig{}, data, muxFromTasks(tasks))
 	if err != nil {
 		t.Errorf("processJob failed on empty payload: %v", err)
 	}
 }
+
+func TestFetchConsumesEveryQueue(t *testing.T) {
+	cfg := newTestConfig()
+	g := NewGores(cfg)
+	defer g.Close()
+
+	queues := []string{"emails", "reports"}
+	conn := g.pool.Get()
+	defer conn.Close()
+	for _, q := range queues {
+		_, _ = conn.Do("DEL", g.queueKey(q, QUEUE_PENDING), g.processingKey(q, testWorker))
+	}
+
+	for _, q := range queues {
+		j := &Job{ID: q, Name: "PrintJob", Queue: q}
+		data, _ := j.ToBytes()
+		if _, err := conn.Do("LPUSH", g.queueKey(q, QUEUE_PENDING), data); err != nil {
+			t.Fatalf("lpush: %v", err)
+		}
+	}
+
+	seen := map[string]bool{}
+	for i := 0; i < len(queues); i++ {
+		queue, data, err := g.fetch(conn, testWorker, queues[i:])
+		if err != nil || data == nil {
+			t.Fatalf("fetch: %v (data=%v)", err, data)
+		}
+		seen[queue] = true
+		if n, _ := redis.Int(conn.Do("LLEN", g.processingKey(queue, testWorker))); n != 1 {
+			t.Errorf("expected job on %s processing list, got %d", queue, n)
+		}
+	}
+	if !seen["emails"] || !seen["reports"] {
+		t.Fatalf("expected both queues consumed, got %v", seen)
+	}
+}
+
+func TestRetryOrBuryRetryDisabled(t *testing.T) {
+	cfg := newTestConfig()
+	g := NewGores(cfg)
+	defer g.Close()
+
+	const queue = "retry_queue"
+	conn := g.pool.Get()
+	defer conn.Close()
+	_, _ = conn.Do("DEL", g.processingKey(queue, testWorker), g.queueKey(queue, QUEUE_RETRY), g.queueKey(queue, QUEUE_DEADLETTER))
+
+	j := &Job{ID: "noretry", Name: "FlakyJob", Queue: queue, Retry: false, MaxRetries: 5}
+	data, _ := j.ToBytes()
+	_, _ = conn.Do("LPUSH", g.processingKey(queue, testWorker), data)
+
+	if err := g.retryOrBury(conn, testWorker, queue, data, errors.New("fail")); err != nil {
+		t.Fatalf("retryOrBury: %v", err)
+	}
+	if n, _ := redis.Int(conn.Do("LLEN", g.queueKey(queue, QUEUE_DEADLETTER))); n != 1 {
+		t.Fatalf("expected Retry=false job to be dead-lettered, got %d", n)
+	}
+	if n, _ := redis.Int(conn.Do("ZCARD", g.queueKey(queue, QUEUE_RETRY))); n != 0 {
+		t.Fatalf("expected no retry to be scheduled, got %d", n)
+	}
+}
+
+func TestProcessJobTimeout(t *testing.T) {
+	cfg := newTestConfig()
+	g := NewGores(cfg)
+	defer g.Close()
+
+	j := &Job{ID: "slow", Name: "SlowJob", Queue: "demo_queue", Timeout: 20 * time.Millisecond}
+	data, _ := j.ToBytes()
+
+	release := make(chan struct{})
+	defer close(release)
+	mux := NewServeMux()
+	// Ignores its context, as a hung task would.
+	mux.Handle("SlowJob", TaskFunc(func(ctx context.Context, args map[string]interface{}) error {
+		<-release
+		return nil
+	}))
+
+	start := time.Now()
+	err := processData(g, context.Background(), WorkerConfig{}, data, mux)
+	if !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("expected deadline exceeded, got %v", err)
+	}
+	if time.Since(start) > time.Second {
+		t.Fatalf("hung task blocked the worker for %v", time.Since(start))
+	}
+}
+
+func TestProcessJobSeesDeadlineAndCancellation(t *testing.T) {
+	cfg := newTestConfig()
+	g := NewGores(cfg)
+	defer g.Close()
+
+	j := &Job{ID: "ctx", Name: "CtxJob", Queue: "demo_queue"}
+	data, _ := j.ToBytes()
+
+	hadDeadline := make(chan bool, 1)
+	mux := NewServeMux()
+	mux.HandleFunc("CtxJob", func(ctx context.Context, job *Job) error {
+		_, ok := ctx.Deadline()
+		hadDeadline <- ok
+		<-ctx.Done()
+		return ctx.Err()
+	})
+
+	wcfg := WorkerConfig{TaskTimeouts: map[string]int{"CtxJob": 60}}
+	ctx, cancel := context.WithCancel(context.Background())
+	time.AfterFunc(20*time.Millisecond, cancel)
+	err := processData(g, ctx, wcfg, data, mux)
+	if !errors.Is(err, context.Canceled) {
+		t.Fatalf("expected cancellation, got %v", err)
+	}
+	if !<-hadDeadline {
+		t.Fatal("expected task timeout to set a context deadline")
+	}
+}
+
+func TestJobTimeoutPrecedence(t *testing.T) {
+	cfg := WorkerConfig{Timeout: 30, TaskTimeouts: map[string]int{"Report": 120}}
+	tests := []struct {
+		job  *Job
+		want time.Duration
+	}{
+		{&Job{Name: "Report", Timeout: time.Second}, time.Second},
+		{&Job{Name: "Report"}, 120 * time.Second},
+		{&Job{Name: "Other"}, 30 * time.Second},
+	}
+	for _, tt := range tests {
+		if got := cfg.jobTimeout(tt.job); got != tt.want {
+			t.Errorf("jobTimeout(%s) = %v, want %v", tt.job.Name, got, tt.want)
+		}
+	}
+	if got := (WorkerConfig{}).jobTimeout(&Job{Name: "x"}); got != 0 {
+		t.Errorf("expected no timeout by default, got %v", got)
+	}
+}
+
+func TestRetryOrBuryNonRetryable(t *testing.T) {
+	cfg := newTestConfig()
+	g := NewGores(cfg)
+	defer g.Close()
+
+	const queue = "retry_queue"
+	conn := g.pool.Get()
+	defer conn.Close()
+	_, _ = conn.Do("DEL", g.processingKey(queue, testWorker), g.queueKey(queue, QUEUE_RETRY), g.queueKey(queue, QUEUE_DEADLETTER))
+
+	j := &Job{ID: "unknown", Name: "Missing", Queue: queue, Retry: true}
+	data, _ := j.ToBytes()
+	_, _ = conn.Do("LPUSH", g.processingKey(queue, testWorker), data)
+
+	cause := NewServeMux().ProcessJob(context.Background(), j)
+	if err := g.retryOrBury(conn, testWorker, queue, data, cause); err != nil {
+		t.Fatalf("retryOrBury: %v", err)
+	}
+	if n, _ := redis.Int(conn.Do("LLEN", g.queueKey(queue, QUEUE_DEADLETTER))); n != 1 {
+		t.Fatalf("expected unknown task to be dead-lettered, got %d", n)
+	}
+}
+
+func TestProcessJobRecoversPanic(t *testing.T) {
+	cfg := newTestConfig()
+	g := NewGores(cfg)
+	defer g.Close()
+
+	j := &Job{ID: "boom", Name: "PanicJob", Queue: "demo_queue", Retry: true}
+	data, _ := j.ToBytes()
+
+	tasks := map[string]func(map[string]interface{}) error{
+		"PanicJob": func(args map[string]interface{}) error {
+			var m map[string]int
+			m["x"] = 1
+			return nil
+		},
+	}
+
+	err := processData(g, context.Background(), WorkerConfig{}, data, muxFromTasks(tasks))
+	var perr *PanicError
+	if !errors.As(err, &perr) {
+		t.Fatalf("expected *PanicError, got %v", err)
+	}
+	if !IsRetryable(err) {
+		t.Error("panics should go through the normal retry flow")
+	}
+	if !strings.Contains(string(perr.Stack), "TestProcessJobRecoversPanic") {
+		t.Errorf("expected stack trace to include the panicking task, got:\n%s", perr.Stack)
+	}
+}
@@ -1,27 +1,39 @@
 package main
 
 import (
+	"context"
 	"encoding/json"
 	"flag"
 	"fmt"
 	"log"
+	"os"
+	"os/signal"
+	"syscall"
 	"time"
 
 	lib "myproject/gores/lib_optimized"
 )
 
-var tasks = map[string]func(map[string]interface{}) error{
-	"PrintJob": func(args map[string]interface{}) error {
-		id := int(args["id"].(float64))
-		fmt.Printf("✅ PrintJob ID: %d at %s\n", id, time.Now().Format("15:04:05"))
+type PrintArgs struct {
+	ID int `msgpack:"id" json:"id"`
+}
+
+type CalcArgs struct {
+	A float64 `msgpack:"a" json:"a"`
+	B float64 `msgpack:"b" json:"b"`
+}
+
+func newMux() *lib.ServeMux {
+	mux := lib.NewServeMux()
+	lib.RegisterTyped(mux, "PrintJob", func(ctx context.Context, args PrintArgs) error {
+		fmt.Printf("✅ PrintJob ID: %d at %s\n", args.ID, time.Now().Format("15:04:05"))
 		return nil
-	},
-	"CalcJob": func(args map[string]interface{}) error {
-		a := args["a"].(float64)
-		b := args["b"].(float64)
-		fmt.Printf("🧮 Calc: %.2f * %.2f = %.2f\n", a, b, a*b)
+	})
+	lib.RegisterTyped(mux, "CalcJob", func(ctx context.Context, args CalcArgs) error {
+		fmt.Printf("🧮 Calc: %.2f * %.2f = %.2f\n", args.A, args.B, args.A*args.B)
 		return nil
-	},
+	})
+	return mux
 }
 
 func runProducer(g *lib.Gores) {
@@ -46,14 +58,70 @@ func runProducer(g *lib.Gores) {
 	fmt.Printf("\n📊 Stats:\n%s\n", data)
 }
 
-func runConsumer(g *lib.Gores, numWorkers int) {
+func serveMetrics(g *lib.Gores, config *lib.Config) {
+	if config.MetricsAddr == "" {
+		return
+	}
+	go func() {
+		log.Printf("Metrics: %v", g.ServeMetrics(config.MetricsAddr))
+	}()
+	fmt.Printf("📈 Metrics on http://%s%s\n", config.MetricsAddr, lib.METRICS_PATH)
+}
+
+// awaitSignal keeps a producer serving metrics until it is interrupted.
+func awaitSignal(config *lib.Config) {
+	if config.MetricsAddr == "" {
+		return
+	}
+	fmt.Println("📈 Serving metrics until interrupted...")
+	sigs := make(chan os.Signal, 1)
+	signal.Notify(sigs, syscall.SIGINT, syscall.SIGTERM)
+	<-sigs
+}
+
+func runConsumer(g *lib.Gores, config *lib.Config, numWorkers int) {
 	fmt.Println("🚀 Consume: Starting", numWorkers, "workers...")
-	g.StartWorkers(numWorkers, tasks)
+	g.StartWorkerPool(numWorkers, config.Worker, newMux())
+}
+
+func runWorkers(g *lib.Gores) {
+	workers, err := g.Workers()
+	if err != nil {
+		log.Fatalf("Workers: %v", err)
+	}
+	data, _ := json.MarshalIndent(workers, "", "  ")
+	fmt.Printf("👷 Workers:\n%s\n", data)
+}
+
+func runStatus(g *lib.Gores, id string) {
+	status, err := g.GetJobStatus(id)
+	if err != nil {
+		log.Fatalf("Status: %v", err)
+	}
+	data, _ := json.MarshalIndent(status, "", "  ")
+	fmt.Printf("🔎 Job %s:\n%s\n", id, data)
+}
+
+func runPoison(g *lib.Gores, queue string) {
+	msgs, err := g.PoisonMessages(queue, 0, 20)
+	if err != nil {
+		log.Fatalf("Poison: %v", err)
+	}
+	fmt.Printf("☣️  %d poison messages on %s\n", len(msgs), queue)
+	for _, m := range msgs {
+		if m.DecodeError != "" {
+			fmt.Printf("\nunreadable entry: %s\n%s", m.DecodeError, m.HexDump())
+			continue
+		}
+		fmt.Printf("\n%s: %s\n%s", time.Unix(int64(m.QuarantinedAt), 0).Format(time.RFC3339), m.Error, m.HexDump())
+	}
 }
 
 func main() {
 	configPath := flag.String("c", "config.json", "config")
-	mode := flag.String("o", "produce", "produce/consume")
+	mode := flag.String("o", "produce", "produce/consume/workers/status/poison")
+	jobID := flag.String("id", "", "job ID for -o status")
+	queue := flag.String("q", lib.DEFAULT_QUEUE, "queue for -o poison")
 	numWorkers := flag.Int("w", 3, "workers")
 	bench := flag.Bool("bench", false, "run benchmarks only") // ADD THIS
 	flag.Parse()
@@ -73,10 +141,19 @@ func main() {
 
 	switch *mode {
 	case "produce":
+		serveMetrics(g, config)
 		runProducer(g)
+		awaitSignal(config)
 	case "consume":
-		runConsumer(g, *numWorkers)
+		serveMetrics(g, config)
+		runConsumer(g, config, *numWorkers)
+	case "workers":
+		runWorkers(g)
+	case "status":
+		runStatus(g, *jobID)
+	case "poison":
+		runPoison(g, *queue)
 	default:
-		log.Fatal("Mode must be 'produce' or 'consume'")
+		log.Fatal("Mode must be 'produce', 'consume', 'workers', 'status' or 'poison'")
 	}
 }